@@ -0,0 +1,233 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultShell runs the commands that are given as strings.
+var DefaultShell = []string{"/bin/sh", "-c"}
+
+// Command is a command setting: either a command line, which is run through
+// the app's shell, or an argv array, which is run directly.
+type Command struct {
+	Line string   `toml:",inline"`
+	Argv []string `toml:",inline"`
+}
+
+// IsZero reports whether no command is set.
+func (c Command) IsZero() bool {
+	return c.Line == "" && len(c.Argv) == 0
+}
+
+// Args returns the argv that runs the command, running a command line with
+// shell.
+func (c Command) Args(shell []string) []string {
+	if len(c.Argv) > 0 {
+		return append([]string{}, c.Argv...)
+	}
+	if c.Line == "" {
+		return nil
+	}
+	return append(append([]string{}, shell...), c.Line)
+}
+
+// Words returns the words of the command. A command line is split the way a
+// POSIX shell would, without expanding anything, so operators such as && and
+// | are returned as words too.
+func (c Command) Words() []string {
+	if len(c.Argv) > 0 {
+		return append([]string{}, c.Argv...)
+	}
+	words, _ := splitWords(c.Line)
+	values := make([]string, len(words))
+	for i, w := range words {
+		values[i] = w.value
+	}
+	return values
+}
+
+// ReplaceWord returns the command with every word equal to old replaced by
+// new, quoted as needed, and whether there was any.
+func (c Command) ReplaceWord(old, new string) (Command, bool) {
+	found := false
+
+	if len(c.Argv) > 0 {
+		argv := append([]string{}, c.Argv...)
+		for i, arg := range argv {
+			if arg == old {
+				argv[i] = new
+				found = true
+			}
+		}
+		return Command{Argv: argv}, found
+	}
+
+	words, err := splitWords(c.Line)
+	if err != nil {
+		return c, false
+	}
+	var line strings.Builder
+	last := 0
+	for _, w := range words {
+		if w.value != old {
+			continue
+		}
+		line.WriteString(c.Line[last:w.start])
+		line.WriteString(Quote(new))
+		last = w.end
+		found = true
+	}
+	line.WriteString(c.Line[last:])
+	return Command{Line: line.String()}, found
+}
+
+// Map returns the command with mapping applied to the command line, or to
+// each argument.
+func (c Command) Map(mapping func(string) string) Command {
+	if len(c.Argv) > 0 {
+		argv := make([]string, len(c.Argv))
+		for i, arg := range c.Argv {
+			argv[i] = mapping(arg)
+		}
+		return Command{Argv: argv}
+	}
+	return Command{Line: mapping(c.Line)}
+}
+
+func (c Command) String() string {
+	if len(c.Argv) > 0 {
+		quoted := make([]string, len(c.Argv))
+		for i, arg := range c.Argv {
+			quoted[i] = Quote(arg)
+		}
+		return strings.Join(quoted, " ")
+	}
+	return c.Line
+}
+
+// parseCommand reads a command setting, which is either a string or an array
+// of strings.
+func parseCommand(value interface{}) Command {
+	switch v := value.(type) {
+	case string:
+		return Command{Line: v}
+	case []interface{}:
+		var argv []string
+		for _, item := range v {
+			if arg, ok := item.(string); ok {
+				argv = append(argv, arg)
+			}
+		}
+		return Command{Argv: argv}
+	}
+	return Command{}
+}
+
+func parseCommands(value interface{}) []Command {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	commands := make([]Command, 0, len(list))
+	for _, item := range list {
+		if cmd := parseCommand(item); !cmd.IsZero() {
+			commands = append(commands, cmd)
+		}
+	}
+	return commands
+}
+
+// SplitWords splits a command line into words the way a POSIX shell would,
+// handling quotes and backslashes but expanding nothing.
+func SplitWords(line string) ([]string, error) {
+	words, err := splitWords(line)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, len(words))
+	for i, w := range words {
+		values[i] = w.value
+	}
+	return values, nil
+}
+
+// Quote returns s quoted for a POSIX shell, if it needs to be.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(s, " \t\n'\"\\$`|&;<>()*?[]#~{}!") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// word is a word of a command line along with where it is in the line.
+type word struct {
+	value      string
+	start, end int
+}
+
+func splitWords(line string) ([]word, error) {
+	var (
+		words   []word
+		current strings.Builder
+		inWord  bool
+		start   int
+	)
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word{value: current.String(), start: start, end: i})
+				current.Reset()
+				inWord = false
+			}
+			continue
+		case !inWord:
+			inWord = true
+			start = i
+		}
+
+		switch c {
+		case '\\':
+			if i+1 < len(line) {
+				i++
+				if line[i] != '\n' {
+					current.WriteByte(line[i])
+				}
+			}
+		case '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote in %q", line)
+			}
+			current.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+		case '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("$`\"\\\n", line[i+1]) >= 0 {
+					i++
+					if line[i] == '\n' {
+						continue
+					}
+				}
+				current.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated double quote in %q", line)
+			}
+		default:
+			current.WriteByte(c)
+		}
+	}
+	if inWord {
+		words = append(words, word{value: current.String(), start: start, end: len(line)})
+	}
+
+	return words, nil
+}
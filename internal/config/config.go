@@ -2,202 +2,782 @@ package config
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
 
 type App struct {
-	Name          string
-	RunCmd        string            `toml:"run_cmd"`
-	BuildCmd      string            `toml:"build_cmd"`
-	Cmd           string            `toml:"cmd"`
-	Bin           string            `toml:"bin"`
-	Args          []string          `toml:"args"`
-	WatchDir      string            `toml:"watch_dir"`
-	TmpDir        string            `toml:"tmp_dir"`
-	Env           map[string]string `toml:"env"`
-	Delay         int               `toml:"delay"`
-	KillDelay     string            `toml:"kill_delay"`
-	Rerun         bool              `toml:"rerun"`
-	RerunDelay    int               `toml:"rerun_delay"`
-	ExcludeDir    []string          `toml:"exclude_dir"`
-	ExcludeFile   []string          `toml:"exclude_file"`
-	ExcludeRegex  []string          `toml:"exclude_regex"`
-	FollowSymlink bool              `toml:"follow_symlink"`
-	PreCmd        []string          `toml:"pre_cmd"`
-	PostCmd       []string          `toml:"post_cmd"`
-	SendInterrupt bool              `toml:"send_interrupt"`
-	StopOnError   bool              `toml:"stop_on_error"`
-	LogSilent     bool              `toml:"log_silent"`
-	CleanOnExit   bool              `toml:"clean_on_exit"`
+	Name                    string
+	RunCmd                  Command           `toml:"run_cmd"`
+	BuildCmd                Command           `toml:"build_cmd"`
+	Cmd                     Command           `toml:"cmd"`
+	Shell                   []string          `toml:"shell"`
+	Bin                     string            `toml:"bin"`
+	Args                    []string          `toml:"args"`
+	WatchDir                []string          `toml:"watch_dir"`
+	WorkingDir              string            `toml:"working_dir"`
+	TmpDir                  string            `toml:"tmp_dir"`
+	Env                     map[string]string `toml:"env"`
+	EnvFile                 []string          `toml:"env_file"`
+	Delay                   int               `toml:"delay"`
+	KillDelay               string            `toml:"kill_delay"`
+	StopTimeout             string            `toml:"stop_timeout"`
+	Rerun                   bool              `toml:"rerun"`
+	RerunDelay              int               `toml:"rerun_delay"`
+	ExcludeDir              []string          `toml:"exclude_dir"`
+	ExcludeFile             []string          `toml:"exclude_file"`
+	ExcludeRegex            []string          `toml:"exclude_regex"`
+	IncludeExt              []string          `toml:"include_ext"`
+	IncludeFile             []string          `toml:"include_file"`
+	WatchGoDeps             bool              `toml:"watch_go_deps"`
+	GoPackage               string            `toml:"go_package"`
+	FollowSymlink           bool              `toml:"follow_symlink"`
+	UseGitignore            bool              `toml:"use_gitignore"`
+	WatchMode               string            `toml:"watch_mode"`
+	PollInterval            int               `toml:"poll_interval"`
+	PollHash                bool              `toml:"poll_hash"`
+	IgnoreUnchanged         bool              `toml:"ignore_unchanged"`
+	WaitForGit              bool              `toml:"wait_for_git"`
+	PreCmd                  []Command         `toml:"pre_cmd"`
+	PostCmd                 []Command         `toml:"post_cmd"`
+	SendInterrupt           bool              `toml:"send_interrupt"`
+	StopOnError             bool              `toml:"stop_on_error"`
+	LogSilent               bool              `toml:"log_silent"`
+	CleanOnExit             bool              `toml:"clean_on_exit"`
+	Debounce                int               `toml:"debounce"`
+	StopDelay               int               `toml:"stop_delay"`
+	StartDelay              int               `toml:"start_delay"`
+	Enabled                 bool              `toml:"enabled"`
+	DependsOn               []string          `toml:"depends_on"`
+	RestartWithDependencies bool              `toml:"restart_with_dependencies"`
+	Ready                   *ReadyCheck       `toml:"ready"`
+	Restart                 string            `toml:"restart"`
+	MaxRestarts             int               `toml:"max_restarts"`
+	RestartBackoff          string            `toml:"restart_backoff"`
+	CrashLimit              int               `toml:"crash_limit"`
+	CrashWindow             string            `toml:"crash_window"`
+	Liveness                *LivenessCheck    `toml:"liveness"`
+	BuildTimeout            string            `toml:"build_timeout"`
+	BuildSwap               bool              `toml:"build_swap"`
+	SkipUnchanged           bool              `toml:"skip_unchanged"`
+	OnChange                []ChangeRule      `toml:"on_change"`
+}
+
+// ReadyCheck describes how to tell that an app has finished starting up.
+// Exactly one of HTTP, TCP, Log and File is set.
+type ReadyCheck struct {
+	// HTTP is a URL that must answer a GET request with Status.
+	HTTP   string `toml:"http"`
+	Status int    `toml:"status"`
+	// TCP is an address, such as "localhost:5432", that must accept
+	// connections.
+	TCP string `toml:"tcp"`
+	// Log is a regular expression matched against the app's output.
+	Log string `toml:"log"`
+	// File is a path that must exist.
+	File     string `toml:"file"`
+	Interval string `toml:"interval"`
+	Timeout  string `toml:"timeout"`
+}
+
+// checks returns how many kinds of check are configured.
+func (r *ReadyCheck) checks() int {
+	n := 0
+	for _, check := range []string{r.HTTP, r.TCP, r.Log, r.File} {
+		if check != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// LivenessCheck describes how to tell that a running app still responds.
+// Exactly one of HTTP, TCP and Exec is set.
+type LivenessCheck struct {
+	// HTTP is a URL that must answer a GET request with Status.
+	HTTP   string `toml:"http"`
+	Status int    `toml:"status"`
+	// TCP is an address that must accept connections.
+	TCP string `toml:"tcp"`
+	// Exec is a command that must exit successfully.
+	Exec     Command `toml:"exec"`
+	Interval string  `toml:"interval"`
+	Timeout  string  `toml:"timeout"`
+	// FailureThreshold is how many checks in a row must fail before the app
+	// is restarted.
+	FailureThreshold int `toml:"failure_threshold"`
+	// GoroutineDump sends SIGQUIT before restarting and saves what the app
+	// prints, the goroutine dump for Go programs, into DumpDir. It defaults
+	// to true for apps built with "go build".
+	GoroutineDump bool `toml:"goroutine_dump"`
+	// DumpDir is where goroutine dumps are saved, the system's temporary
+	// directory by default. It outlives the session, so that dumps can be
+	// read after wisp exits.
+	DumpDir string `toml:"dump_dir"`
+}
+
+// checks returns how many kinds of check are configured.
+func (l *LivenessCheck) checks() int {
+	n := 0
+	for _, check := range []string{l.HTTP, l.TCP} {
+		if check != "" {
+			n++
+		}
+	}
+	if !l.Exec.IsZero() {
+		n++
+	}
+	return n
 }
 
 type Config struct {
 	Apps map[string]*App
+	// Files are the configuration files the apps were loaded from, in the
+	// order they were layered.
+	Files []string
+	// SessionDir is the value of ${session_dir} the apps were expanded with.
+	SessionDir string
+	// Profile is the name of the selected profile, if any.
+	Profile string
+
+	sources map[string]source
 }
 
-func Load(configPath string) (*Config, error) {
-	if configPath == "" {
-		configPath = "wisp.toml"
+// Options adjusts how configuration files are loaded.
+type Options struct {
+	// SessionDir is substituted for ${session_dir}. The directory does not
+	// need to exist yet.
+	SessionDir string
+	// Profile selects the [profile.<name>] tables layered over each file.
+	Profile string
+}
+
+// Source reports the file and line an app setting was defined at, such as
+// Source("api", "env", "PORT"), or "" if it has its built-in default.
+func (c *Config) Source(key ...string) string {
+	if src, ok := c.sources[strings.Join(key, ".")]; ok {
+		return src.String()
 	}
+	return ""
+}
+
+// defaultsTables are the reserved table names whose fields are inherited by
+// every app. Only one of them may be present in a configuration file.
+var defaultsTables = []string{"wisp", "defaults"}
+
+const (
+	listMergeReplace = "replace"
+	listMergeAppend  = "append"
+)
+
+// singleCommands are the settings holding one command, whose arrays are its
+// arguments. They are always replaced, whatever list_merge says.
+var singleCommands = map[string]bool{
+	"run_cmd":   true,
+	"build_cmd": true,
+	"cmd":       true,
+	"shell":     true,
+	"exec":      true,
+}
+
+// Watch modes, which select how file changes are noticed.
+const (
+	WatchModeNotify = "notify"
+	WatchModePoll   = "poll"
+)
+
+// Restart policies for apps that exit on their own.
+const (
+	RestartNo        = "no"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+func Load(configPath string) (*Config, error) {
+	return LoadWithOptions([]string{configPath}, Options{})
+}
 
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("configuration file %s not found", configPath)
+// LoadWithOptions loads the given configuration files, plus any local
+// override files next to them, layering each on top of the previous ones.
+func LoadWithOptions(configPaths []string, opts Options) (*Config, error) {
+	files := ConfigFiles(configPaths)
+
+	docs := make([]*document, 0, len(files))
+	for _, path := range files {
+		doc, err := readDocument(path)
+		if err != nil {
+			if _, isParseErr := err.(toml.ParseError); isParseErr {
+				return nil, fmt.Errorf("failed to parse TOML in %s: %w", path, err)
+			}
+			return nil, err
+		}
+		docs = append(docs, doc)
 	}
 
-	data, err := os.ReadFile(configPath)
+	rawConfig, sources, err := layerDocuments(docs, opts.Profile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
 	}
 
-	var rawConfig map[string]interface{}
-	if err := toml.Unmarshal(data, &rawConfig); err != nil {
-		return nil, fmt.Errorf("failed to parse TOML: %w", err)
+	defaults, defaultsName, err := defaultsTable(rawConfig)
+	if err != nil {
+		return nil, err
 	}
 
 	config := &Config{
-		Apps: make(map[string]*App),
+		Apps:       make(map[string]*App),
+		Files:      files,
+		SessionDir: opts.SessionDir,
+		Profile:    opts.Profile,
+		sources:    make(map[string]source),
 	}
 
 	for name, value := range rawConfig {
+		if isDefaultsTable(name) {
+			continue
+		}
+
 		appMap, ok := value.(map[string]interface{})
 		if !ok {
 			continue
 		}
 
-		app := &App{
-			Name: name,
+		merged, err := mergeTables(defaults, appMap)
+		if err != nil {
+			return nil, fmt.Errorf("app '%s': %w", name, err)
 		}
 
-		if runCmd, ok := appMap["run_cmd"].(string); ok {
-			app.RunCmd = runCmd
-		}
-		if buildCmd, ok := appMap["build_cmd"].(string); ok {
-			app.BuildCmd = buildCmd
-		}
-		if cmd, ok := appMap["cmd"].(string); ok {
-			app.Cmd = cmd
-		}
-		if bin, ok := appMap["bin"].(string); ok {
-			app.Bin = bin
-		}
-		if watchDir, ok := appMap["watch_dir"].(string); ok {
-			app.WatchDir = watchDir
-		} else {
+		config.Apps[name] = parseApp(name, merged)
+		inheritSources(name, defaultsName, merged, nil, sources)
+	}
 
-			app.WatchDir = "."
+	for key, src := range sources {
+		if app, _, _ := strings.Cut(key, "."); config.Apps[app] != nil {
+			config.sources[key] = src
 		}
-		if tmpDir, ok := appMap["tmp_dir"].(string); ok {
-			app.TmpDir = tmpDir
-		} else {
-			app.TmpDir = "/tmp"
+	}
+
+	dir := configDir(files[0])
+	apps, errs := interpolate(config.Apps, runtimeVars(opts), dir)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("app %w", errs[0])
+	}
+	config.Apps = apps
+
+	if err := checkDependencies(config.Apps); err != nil {
+		return nil, err
+	}
+
+	for _, app := range config.Apps {
+		resolvePaths(app, dir, config.sources)
+	}
+
+	return config, nil
+}
+
+// resolvePaths makes the paths of an app absolute. working_dir, watch_dir and
+// env_file are relative to the directory of the configuration file that set
+// them, according to sources, and working_dir defaults to baseDir.
+// ready.file is relative to working_dir, like the paths in the app's
+// commands, which are resolved when they run.
+func resolvePaths(app *App, baseDir string, sources map[string]source) {
+	resolve := func(dir, path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
 		}
-		if killDelay, ok := appMap["kill_delay"].(string); ok {
-			app.KillDelay = killDelay
+		return filepath.Join(dir, path)
+	}
+	dirOf := func(key string) string {
+		if src, ok := sources[app.Name+"."+key]; ok {
+			return configDir(src.file)
 		}
+		return baseDir
+	}
 
-		if delay, ok := appMap["delay"].(int64); ok {
-			app.Delay = int(delay)
-		} else {
-			app.Delay = 1000
-		}
-		if rerunDelay, ok := appMap["rerun_delay"].(int64); ok {
-			app.RerunDelay = int(rerunDelay)
-		} else {
-			app.RerunDelay = 500
+	if app.WorkingDir == "" {
+		app.WorkingDir = baseDir
+	}
+	app.WorkingDir = resolve(dirOf("working_dir"), app.WorkingDir)
+	for i, watchDir := range app.WatchDir {
+		app.WatchDir[i] = resolve(dirOf("watch_dir"), watchDir)
+	}
+	for i, envFile := range app.EnvFile {
+		app.EnvFile[i] = resolve(dirOf("env_file"), envFile)
+	}
+	if app.Ready != nil {
+		app.Ready.File = resolve(app.WorkingDir, app.Ready.File)
+	}
+	if app.Liveness != nil {
+		app.Liveness.DumpDir = resolve(app.WorkingDir, app.Liveness.DumpDir)
+	}
+}
+
+// parseApp builds an App from its (already merged) TOML table, filling in
+// built-in defaults for anything left unset.
+func parseApp(name string, appMap map[string]interface{}) *App {
+	app := &App{
+		Name: name,
+	}
+
+	app.RunCmd = parseCommand(appMap["run_cmd"])
+	app.BuildCmd = parseCommand(appMap["build_cmd"])
+	app.Cmd = parseCommand(appMap["cmd"])
+	if shell, ok := appMap["shell"].([]interface{}); ok {
+		for _, arg := range shell {
+			if strArg, ok := arg.(string); ok {
+				app.Shell = append(app.Shell, strArg)
+			}
 		}
+	} else {
+		app.Shell = append([]string{}, DefaultShell...)
+	}
+	if bin, ok := appMap["bin"].(string); ok {
+		app.Bin = bin
+	}
+	if workingDir, ok := appMap["working_dir"].(string); ok {
+		app.WorkingDir = workingDir
+	}
+	if watchDir := stringOrList(appMap["watch_dir"]); len(watchDir) > 0 {
+		app.WatchDir = watchDir
+	} else {
+		app.WatchDir = []string{"."}
+	}
+	if tmpDir, ok := appMap["tmp_dir"].(string); ok {
+		app.TmpDir = tmpDir
+	} else {
+		app.TmpDir = "/tmp"
+	}
+	if killDelay, ok := appMap["kill_delay"].(string); ok {
+		app.KillDelay = killDelay
+	}
+	if stopTimeout, ok := appMap["stop_timeout"].(string); ok {
+		app.StopTimeout = stopTimeout
+	} else {
+		app.StopTimeout = "5s"
+	}
+	if restart, ok := appMap["restart"].(string); ok {
+		app.Restart = restart
+	} else {
+		app.Restart = RestartNo
+	}
+	if restartBackoff, ok := appMap["restart_backoff"].(string); ok {
+		app.RestartBackoff = restartBackoff
+	} else {
+		app.RestartBackoff = "1s"
+	}
+	if crashWindow, ok := appMap["crash_window"].(string); ok {
+		app.CrashWindow = crashWindow
+	} else {
+		app.CrashWindow = "1m"
+	}
+	if buildTimeout, ok := appMap["build_timeout"].(string); ok {
+		app.BuildTimeout = buildTimeout
+	} else {
+		app.BuildTimeout = "5m"
+	}
+
+	if delay, ok := appMap["delay"].(int64); ok {
+		app.Delay = int(delay)
+	} else {
+		app.Delay = 1000
+	}
+	if rerunDelay, ok := appMap["rerun_delay"].(int64); ok {
+		app.RerunDelay = int(rerunDelay)
+	} else {
+		app.RerunDelay = 500
+	}
+	if debounce, ok := appMap["debounce"].(int64); ok {
+		app.Debounce = int(debounce)
+	} else {
+		app.Debounce = 300
+	}
+	if stopDelay, ok := appMap["stop_delay"].(int64); ok {
+		app.StopDelay = int(stopDelay)
+	}
+	if startDelay, ok := appMap["start_delay"].(int64); ok {
+		app.StartDelay = int(startDelay)
+	} else {
+		app.StartDelay = 500
+	}
+	if maxRestarts, ok := appMap["max_restarts"].(int64); ok {
+		app.MaxRestarts = int(maxRestarts)
+	} else {
+		app.MaxRestarts = 10
+	}
+	if crashLimit, ok := appMap["crash_limit"].(int64); ok {
+		app.CrashLimit = int(crashLimit)
+	} else {
+		app.CrashLimit = 5
+	}
 
-		if rerun, ok := appMap["rerun"].(bool); ok {
-			app.Rerun = rerun
+	if rerun, ok := appMap["rerun"].(bool); ok {
+		app.Rerun = rerun
+	}
+	if buildSwap, ok := appMap["build_swap"].(bool); ok {
+		app.BuildSwap = buildSwap
+	}
+	if skipUnchanged, ok := appMap["skip_unchanged"].(bool); ok {
+		app.SkipUnchanged = skipUnchanged
+	} else {
+		app.SkipUnchanged = true
+	}
+	if followSymlink, ok := appMap["follow_symlink"].(bool); ok {
+		app.FollowSymlink = followSymlink
+	}
+	if useGitignore, ok := appMap["use_gitignore"].(bool); ok {
+		app.UseGitignore = useGitignore
+	} else {
+		app.UseGitignore = true
+	}
+	if watchMode, ok := appMap["watch_mode"].(string); ok {
+		app.WatchMode = watchMode
+	} else {
+		app.WatchMode = WatchModeNotify
+	}
+	if pollInterval, ok := appMap["poll_interval"].(int64); ok {
+		app.PollInterval = int(pollInterval)
+	} else {
+		app.PollInterval = 500
+	}
+	if pollHash, ok := appMap["poll_hash"].(bool); ok {
+		app.PollHash = pollHash
+	}
+	if ignoreUnchanged, ok := appMap["ignore_unchanged"].(bool); ok {
+		app.IgnoreUnchanged = ignoreUnchanged
+	} else {
+		app.IgnoreUnchanged = true
+	}
+	if waitForGit, ok := appMap["wait_for_git"].(bool); ok {
+		app.WaitForGit = waitForGit
+	} else {
+		app.WaitForGit = true
+	}
+	if watchGoDeps, ok := appMap["watch_go_deps"].(bool); ok {
+		app.WatchGoDeps = watchGoDeps
+	}
+	if goPackage, ok := appMap["go_package"].(string); ok {
+		app.GoPackage = goPackage
+	}
+	if sendInterrupt, ok := appMap["send_interrupt"].(bool); ok {
+		app.SendInterrupt = sendInterrupt
+	}
+	if stopOnError, ok := appMap["stop_on_error"].(bool); ok {
+		app.StopOnError = stopOnError
+	}
+	if logSilent, ok := appMap["log_silent"].(bool); ok {
+		app.LogSilent = logSilent
+	}
+	if cleanOnExit, ok := appMap["clean_on_exit"].(bool); ok {
+		app.CleanOnExit = cleanOnExit
+	}
+	if enabled, ok := appMap["enabled"].(bool); ok {
+		app.Enabled = enabled
+	} else {
+		app.Enabled = true
+	}
+	if restartWithDependencies, ok := appMap["restart_with_dependencies"].(bool); ok {
+		app.RestartWithDependencies = restartWithDependencies
+	}
+
+	if args, ok := appMap["args"].([]interface{}); ok {
+		for _, arg := range args {
+			if strArg, ok := arg.(string); ok {
+				app.Args = append(app.Args, strArg)
+			}
 		}
-		if followSymlink, ok := appMap["follow_symlink"].(bool); ok {
-			app.FollowSymlink = followSymlink
+	}
+	if excludeDir, ok := appMap["exclude_dir"].([]interface{}); ok {
+		for _, dir := range excludeDir {
+			if strDir, ok := dir.(string); ok {
+				app.ExcludeDir = append(app.ExcludeDir, strDir)
+			}
 		}
-		if sendInterrupt, ok := appMap["send_interrupt"].(bool); ok {
-			app.SendInterrupt = sendInterrupt
+	}
+	if excludeFile, ok := appMap["exclude_file"].([]interface{}); ok {
+		for _, file := range excludeFile {
+			if strFile, ok := file.(string); ok {
+				app.ExcludeFile = append(app.ExcludeFile, strFile)
+			}
 		}
-		if stopOnError, ok := appMap["stop_on_error"].(bool); ok {
-			app.StopOnError = stopOnError
+	}
+	if excludeRegex, ok := appMap["exclude_regex"].([]interface{}); ok {
+		for _, regex := range excludeRegex {
+			if strRegex, ok := regex.(string); ok {
+				app.ExcludeRegex = append(app.ExcludeRegex, strRegex)
+			}
 		}
-		if logSilent, ok := appMap["log_silent"].(bool); ok {
-			app.LogSilent = logSilent
+	}
+	if includeExt, ok := appMap["include_ext"].([]interface{}); ok {
+		for _, ext := range includeExt {
+			if strExt, ok := ext.(string); ok {
+				app.IncludeExt = append(app.IncludeExt, strExt)
+			}
 		}
-		if cleanOnExit, ok := appMap["clean_on_exit"].(bool); ok {
-			app.CleanOnExit = cleanOnExit
+	}
+	if includeFile, ok := appMap["include_file"].([]interface{}); ok {
+		for _, file := range includeFile {
+			if strFile, ok := file.(string); ok {
+				app.IncludeFile = append(app.IncludeFile, strFile)
+			}
 		}
+	}
+	app.PreCmd = parseCommands(appMap["pre_cmd"])
+	app.PostCmd = parseCommands(appMap["post_cmd"])
+	app.OnChange = parseChangeRules(appMap["on_change"])
 
-		if args, ok := appMap["args"].([]interface{}); ok {
-			for _, arg := range args {
-				if strArg, ok := arg.(string); ok {
-					app.Args = append(app.Args, strArg)
-				}
-			}
+	if ready, ok := appMap["ready"].(map[string]interface{}); ok {
+		app.Ready = parseReadyCheck(ready)
+	}
+
+	if liveness, ok := appMap["liveness"].(map[string]interface{}); ok {
+		buildCmd := app.BuildCmd
+		if buildCmd.IsZero() {
+			buildCmd = app.Cmd
 		}
-		if excludeDir, ok := appMap["exclude_dir"].([]interface{}); ok {
-			for _, dir := range excludeDir {
-				if strDir, ok := dir.(string); ok {
-					app.ExcludeDir = append(app.ExcludeDir, strDir)
-				}
+		app.Liveness = parseLivenessCheck(liveness, buildCmd)
+	}
+
+	if dependsOn, ok := appMap["depends_on"].([]interface{}); ok {
+		for _, dep := range dependsOn {
+			if strDep, ok := dep.(string); ok {
+				app.DependsOn = append(app.DependsOn, strDep)
 			}
 		}
-		if excludeFile, ok := appMap["exclude_file"].([]interface{}); ok {
-			for _, file := range excludeFile {
-				if strFile, ok := file.(string); ok {
-					app.ExcludeFile = append(app.ExcludeFile, strFile)
-				}
+	}
+
+	app.EnvFile = stringOrList(appMap["env_file"])
+
+	if envMap, ok := appMap["env"].(map[string]interface{}); ok {
+		app.Env = make(map[string]string)
+		for k, v := range envMap {
+			if strVal, ok := v.(string); ok {
+				app.Env[k] = strVal
 			}
 		}
-		if excludeRegex, ok := appMap["exclude_regex"].([]interface{}); ok {
-			for _, regex := range excludeRegex {
-				if strRegex, ok := regex.(string); ok {
-					app.ExcludeRegex = append(app.ExcludeRegex, strRegex)
-				}
+	}
+
+	return app
+}
+
+func parseReadyCheck(table map[string]interface{}) *ReadyCheck {
+	ready := &ReadyCheck{
+		Interval: "500ms",
+		Timeout:  "30s",
+	}
+
+	if http, ok := table["http"].(string); ok {
+		ready.HTTP = http
+		ready.Status = 200
+	}
+	if status, ok := table["status"].(int64); ok {
+		ready.Status = int(status)
+	}
+	if tcp, ok := table["tcp"].(string); ok {
+		ready.TCP = tcp
+	}
+	if log, ok := table["log"].(string); ok {
+		ready.Log = log
+	}
+	if file, ok := table["file"].(string); ok {
+		ready.File = file
+	}
+	if interval, ok := table["interval"].(string); ok {
+		ready.Interval = interval
+	}
+	if timeout, ok := table["timeout"].(string); ok {
+		ready.Timeout = timeout
+	}
+
+	return ready
+}
+
+func parseLivenessCheck(table map[string]interface{}, buildCmd Command) *LivenessCheck {
+	liveness := &LivenessCheck{
+		Interval:         "10s",
+		Timeout:          "5s",
+		FailureThreshold: 3,
+		GoroutineDump:    isGoBuild(buildCmd),
+	}
+
+	if http, ok := table["http"].(string); ok {
+		liveness.HTTP = http
+		liveness.Status = 200
+	}
+	if status, ok := table["status"].(int64); ok {
+		liveness.Status = int(status)
+	}
+	if tcp, ok := table["tcp"].(string); ok {
+		liveness.TCP = tcp
+	}
+	liveness.Exec = parseCommand(table["exec"])
+	if interval, ok := table["interval"].(string); ok {
+		liveness.Interval = interval
+	}
+	if timeout, ok := table["timeout"].(string); ok {
+		liveness.Timeout = timeout
+	}
+	if threshold, ok := table["failure_threshold"].(int64); ok {
+		liveness.FailureThreshold = int(threshold)
+	}
+	if dump, ok := table["goroutine_dump"].(bool); ok {
+		liveness.GoroutineDump = dump
+	}
+	if dumpDir, ok := table["dump_dir"].(string); ok {
+		liveness.DumpDir = dumpDir
+	}
+
+	return liveness
+}
+
+// isGoBuild reports whether a build command builds a Go program.
+func isGoBuild(buildCmd Command) bool {
+	fields := buildCmd.Words()
+	return len(fields) > 1 && fields[0] == "go" && fields[1] == "build"
+}
+
+// runtimeVars returns the interpolation variables that depend on the current
+// run rather than on the configuration itself.
+func runtimeVars(opts Options) map[string]string {
+	vars := make(map[string]string)
+	if opts.SessionDir != "" {
+		vars["session_dir"] = opts.SessionDir
+	}
+	return vars
+}
+
+func configDir(configPath string) string {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return filepath.Dir(configPath)
+	}
+	return filepath.Dir(absPath)
+}
+
+// stringOrList accepts either a single string or an array of strings.
+func stringOrList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				list = append(list, str)
 			}
 		}
-		if preCmd, ok := appMap["pre_cmd"].([]interface{}); ok {
-			for _, cmd := range preCmd {
-				if strCmd, ok := cmd.(string); ok {
-					app.PreCmd = append(app.PreCmd, strCmd)
-				}
-			}
+		return list
+	}
+	return nil
+}
+
+func isDefaultsTable(name string) bool {
+	for _, reserved := range defaultsTables {
+		if name == reserved {
+			return true
 		}
-		if postCmd, ok := appMap["post_cmd"].([]interface{}); ok {
-			for _, cmd := range postCmd {
-				if strCmd, ok := cmd.(string); ok {
-					app.PostCmd = append(app.PostCmd, strCmd)
-				}
-			}
+	}
+	return false
+}
+
+// defaultsTable returns the shared [wisp] or [defaults] table and its name, or
+// nil when the configuration has neither.
+func defaultsTable(rawConfig map[string]interface{}) (map[string]interface{}, string, error) {
+	var (
+		defaults map[string]interface{}
+		found    string
+	)
+
+	for _, name := range defaultsTables {
+		value, ok := rawConfig[name]
+		if !ok {
+			continue
 		}
+		if found != "" {
+			return nil, "", fmt.Errorf("use either [%s] or [%s] for shared settings, not both", found, name)
+		}
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, "", fmt.Errorf("[%s] must be a table", name)
+		}
+		defaults = table
+		found = name
+	}
+
+	return defaults, found, nil
+}
 
-		if envMap, ok := appMap["env"].(map[string]interface{}); ok {
-			app.Env = make(map[string]string)
-			for k, v := range envMap {
-				if strVal, ok := v.(string); ok {
-					app.Env[k] = strVal
-				}
+// mergeTables overlays override on top of base. Nested tables such as env are
+// merged key by key. Lists replace the inherited value unless list_merge is
+// set to "append", in which case the override's items follow the base's,
+// except for the arguments of a single command.
+func mergeTables(base, override map[string]interface{}) (map[string]interface{}, error) {
+	mode := listMergeReplace
+	for _, table := range []map[string]interface{}{base, override} {
+		if value, ok := table["list_merge"]; ok {
+			str, _ := value.(string)
+			if str != listMergeReplace && str != listMergeAppend {
+				return nil, fmt.Errorf("list_merge must be %q or %q, got %v", listMergeReplace, listMergeAppend, value)
 			}
+			mode = str
 		}
+	}
+
+	return mergeValues(base, override, mode == listMergeAppend), nil
+}
 
-		config.Apps[name] = app
+func mergeValues(base, override map[string]interface{}, appendLists bool) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
 	}
 
-	for _, app := range config.Apps {
-		if !filepath.IsAbs(app.WatchDir) {
-			absPath, err := filepath.Abs(app.WatchDir)
-			if err == nil {
-				app.WatchDir = absPath
+	for key, value := range override {
+		switch ov := value.(type) {
+		case map[string]interface{}:
+			if bv, ok := merged[key].(map[string]interface{}); ok {
+				merged[key] = mergeValues(bv, ov, appendLists)
+				continue
+			}
+		case []interface{}:
+			if bv, ok := merged[key].([]interface{}); ok && appendLists && !singleCommands[key] {
+				list := make([]interface{}, 0, len(bv)+len(ov))
+				merged[key] = append(append(list, bv...), ov...)
+				continue
+			}
+		case []map[string]interface{}:
+			if bv, ok := merged[key].([]map[string]interface{}); ok && appendLists {
+				list := make([]map[string]interface{}, 0, len(bv)+len(ov))
+				merged[key] = append(append(list, bv...), ov...)
+				continue
 			}
 		}
+		merged[key] = value
 	}
 
-	return config, nil
+	return merged
 }
 
 func SampleConfig() string {
 	return `# wisp.toml - Wisp configuration file
 
+# Settings shared by every app (optional, may also be named [defaults])
+# [wisp]
+#   exclude_dir = ["vendor", "tmp", "testdata"]
+#   kill_delay = "500ms"
+#   debounce = 300                  # File change debounce (ms)
+#   stop_delay = 0
+#   start_delay = 500
+#   list_merge = "replace"          # "append" adds app lists to these instead
+#   env = { LOG_LEVEL = "debug" }
+
+# Named profiles override settings when selected with --profile or
+# WISP_PROFILE, e.g. "wisp --profile race"
+# [profile.race.api]
+#   build_cmd = "go build -race -o /tmp/api-server ./cmd/api"
+#   env = { GORACE = "halt_on_error=1" }
+
 # The main API server
 [api]
   # required: command to run after build
@@ -210,19 +790,37 @@ func SampleConfig() string {
   build_cmd = "go build -o /tmp/api-server ./cmd/api"
   # OR use 'cmd' as alias:
   # cmd = "go build -o /tmp/api-server ./cmd/api"
+  # commands given as strings run through the shell; arrays run directly:
+  # build_cmd = ["go", "build", "-o", "/tmp/api-server", "./cmd/api"]
+  # shell = ["/bin/sh", "-c"]
   
   # watch configuration
-  watch_dir = "./cmd/api"
+  watch_dir = "./cmd/api"            # Or a list: ["./cmd/api", "./internal"]
+  # working_dir = "."               # Where commands run, relative to this file
   # exclude_dir = ["assets", "tmp", "vendor", "testdata"]
   # exclude_file = ["*.log", "*.tmp"]
   # exclude_regex = [".*_test\\.go$"]
+  # include_ext = ["go", "tmpl", "sql"]   # Only these extensions trigger a rebuild
+  # include_file = ["go.mod", "*.yaml"]   # Or files matching these patterns
+  # watch_go_deps = false          # Watch only the local packages the app imports
+  # go_package = "./cmd/api"       # Main package, if not the one in build_cmd
   # follow_symlink = false
+  # use_gitignore = true           # Skip files ignored by .gitignore (.wispignore always applies)
+  # watch_mode = "notify"          # Or "poll" where change notifications do not arrive
+  # poll_interval = 500            # How often to look for changes when polling (ms)
+  # poll_hash = false              # Ignore files touched without content changes
+  # ignore_unchanged = true        # Ignore saves that leave a file's contents as they were
+  # wait_for_git = true            # Hold changes while git checks out, rebases or merges
   
   # timing configuration (all in milliseconds unless specified)
   # delay = 1000                    # Delay before starting (ms)
   # kill_delay = "500ms"           # Delay after stopping
+  # stop_timeout = "5s"            # Time allowed to stop before a SIGKILL
   # rerun = false                   # Rerun even if build fails
   # rerun_delay = 500              # Delay before rerun (ms)
+  # build_timeout = "5m"           # Kill builds that take longer ("0" for no limit)
+  # build_swap = false             # Keep the old process running until a build succeeds
+  # skip_unchanged = true          # Keep the process running when the binary is rebuilt identical
   
   # command hooks
   # pre_cmd = ["echo 'Building...'"]   # Commands before build
@@ -233,9 +831,26 @@ func SampleConfig() string {
   # stop_on_error = false          # Stop watching on error
   # log_silent = false             # Suppress app output
   # clean_on_exit = false          # Clean tmp files on exit
+  # enabled = true                 # Run with 'wisp' (named apps always run)
+  # depends_on = ["db"]           # Start after these apps, stop before them
+  # restart_with_dependencies = false  # Restart when a dependency restarts
+  # ready = { http = "http://localhost:8080/health" }  # or tcp, log, file
+  # restart = "no"                 # "on-failure" or "always" to restart after exits
+  # max_restarts = 10              # Give up after this many restarts in a row
+  # restart_backoff = "1s"         # First retry delay, doubled on every retry
+  # crash_limit = 5                # Stop retrying after this many crashes...
+  # crash_window = "1m"            # ...within this window
+  # liveness = { http = "http://localhost:8080/health", failure_threshold = 3 }
   # tmp_dir = "/tmp"              # Temp directory path
   
   # environment variables
   env = { PORT = "8080", GIN_MODE = "debug" }
+  # env_file = [".env", ".env.local"]   # dotenv files, reloaded on change
+
+  # what changes to some files do, instead of a full rebuild
+  # [[api.on_change]]
+  # paths = ["templates/**/*.html"]
+  # action = "restart"             # or "rebuild", "signal", "run", "ignore"
+
 `
 }
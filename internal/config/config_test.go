@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// loadString loads a configuration file with the given contents.
+func loadString(t *testing.T, contents string) *Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "wisp.toml")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestLayeredArgvCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		listMerge string
+	}{
+		{name: "replace", listMerge: "replace"},
+		{name: "append", listMerge: "append"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadString(t, `
+[defaults]
+list_merge = "`+tt.listMerge+`"
+run_cmd = ["./bin/default"]
+build_cmd = ["go", "build", "-o", "./bin/default", "."]
+cmd = ["true"]
+shell = ["/bin/sh", "-c"]
+pre_cmd = ["echo defaults"]
+
+[defaults.liveness]
+exec = ["test", "-f", "default"]
+
+[api]
+run_cmd = ["./bin/api", "-v"]
+build_cmd = ["go", "build", "-o", "./bin/api", "./cmd/api"]
+cmd = ["false"]
+shell = ["/bin/bash", "-c"]
+pre_cmd = ["echo api"]
+
+[api.liveness]
+exec = ["test", "-f", "api"]
+`)
+			app := cfg.Apps["api"]
+
+			commands := []struct {
+				key  string
+				got  []string
+				want []string
+			}{
+				{"run_cmd", app.RunCmd.Argv, []string{"./bin/api", "-v"}},
+				{"build_cmd", app.BuildCmd.Argv, []string{"go", "build", "-o", "./bin/api", "./cmd/api"}},
+				{"cmd", app.Cmd.Argv, []string{"false"}},
+				{"shell", app.Shell, []string{"/bin/bash", "-c"}},
+				{"liveness.exec", app.Liveness.Exec.Argv, []string{"test", "-f", "api"}},
+			}
+			for _, c := range commands {
+				if !slices.Equal(c.got, c.want) {
+					t.Errorf("%s = %q, want %q", c.key, c.got, c.want)
+				}
+			}
+
+			wantPre := []string{"echo api"}
+			if tt.listMerge == "append" {
+				wantPre = []string{"echo defaults", "echo api"}
+			}
+			var pre []string
+			for _, cmd := range app.PreCmd {
+				pre = append(pre, cmd.String())
+			}
+			if !slices.Equal(pre, wantPre) {
+				t.Errorf("pre_cmd = %q, want %q", pre, wantPre)
+			}
+		})
+	}
+}
+
+func TestLivenessDumpDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		dumpDir string
+		want    func(app *App) string
+	}{
+		{name: "default", want: func(*App) string { return "" }},
+		{name: "relative", dumpDir: `dump_dir = "dumps"`, want: func(app *App) string { return filepath.Join(app.WorkingDir, "dumps") }},
+		{name: "absolute", dumpDir: `dump_dir = "/var/tmp/dumps"`, want: func(*App) string { return "/var/tmp/dumps" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadString(t, `
+[api]
+run_cmd = "./api"
+
+[api.liveness]
+http = "http://localhost:8080/health"
+`+tt.dumpDir+`
+`)
+			app := cfg.Apps["api"]
+			if got, want := app.Liveness.DumpDir, tt.want(app); got != want {
+				t.Errorf("dump_dir = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// checkDependencies reports depends_on entries naming unknown apps and
+// dependency cycles.
+func checkDependencies(apps map[string]*App) error {
+	names := make([]string, 0, len(apps))
+	for name := range apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := unknownDependency(apps, apps[name]); err != nil {
+			return fmt.Errorf("app '%s': %w", name, err)
+		}
+	}
+
+	if cycle := dependencyCycle(apps); cycle != nil {
+		return fmt.Errorf("app '%s': dependency cycle %s", cycle[0], strings.Join(cycle, " -> "))
+	}
+
+	return nil
+}
+
+func unknownDependency(apps map[string]*App, app *App) error {
+	for _, dep := range app.DependsOn {
+		if dep == app.Name {
+			return fmt.Errorf("depends_on lists the app itself")
+		}
+		if _, exists := apps[dep]; !exists {
+			return fmt.Errorf("depends_on refers to unknown app '%s'", dep)
+		}
+	}
+	return nil
+}
+
+// dependencyCycle returns the apps forming a dependency cycle, starting and
+// ending with the same app, or nil if there is none.
+func dependencyCycle(apps map[string]*App) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(apps))
+	var stack []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		stack = append(stack, name)
+
+		for _, dep := range apps[name].DependsOn {
+			if _, exists := apps[dep]; !exists {
+				continue
+			}
+			switch state[dep] {
+			case visiting:
+				for i, n := range stack {
+					if n == dep {
+						return append(append([]string{}, stack[i:]...), dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		return nil
+	}
+
+	names := make([]string, 0, len(apps))
+	for name := range apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if state[name] == unvisited {
+			if cycle := visit(name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+
+	return nil
+}
+
+// StartOrder groups apps into stages such that every app comes after the
+// apps it depends on; apps within a stage do not depend on each other.
+// Dependencies on apps outside of the given set are ignored.
+func StartOrder(apps map[string]*App) ([][]string, error) {
+	pending := make(map[string]int, len(apps))
+	dependents := make(map[string][]string)
+	for name, app := range apps {
+		pending[name] = 0
+		for _, dep := range app.DependsOn {
+			if _, ok := apps[dep]; ok && dep != name {
+				pending[name]++
+				dependents[dep] = append(dependents[dep], name)
+			}
+		}
+	}
+
+	var stages [][]string
+	for len(pending) > 0 {
+		var stage []string
+		for name, count := range pending {
+			if count == 0 {
+				stage = append(stage, name)
+			}
+		}
+		if len(stage) == 0 {
+			return nil, fmt.Errorf("dependency cycle %s", strings.Join(dependencyCycle(apps), " -> "))
+		}
+		sort.Strings(stage)
+
+		for _, name := range stage {
+			delete(pending, name)
+			for _, dependent := range dependents[name] {
+				pending[dependent]--
+			}
+		}
+		stages = append(stages, stage)
+	}
+
+	return stages, nil
+}
+
+// Dependencies returns the names of the apps name depends on, directly or
+// through other apps.
+func (c *Config) Dependencies(name string) []string {
+	seen := make(map[string]bool)
+	var collect func(name string)
+	collect = func(name string) {
+		app, ok := c.Apps[name]
+		if !ok {
+			return
+		}
+		for _, dep := range app.DependsOn {
+			if !seen[dep] {
+				seen[dep] = true
+				collect(dep)
+			}
+		}
+	}
+	collect(name)
+
+	deps := make([]string, 0, len(seen))
+	for dep := range seen {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+	return deps
+}
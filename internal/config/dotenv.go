@@ -0,0 +1,265 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadEnvFile reads a dotenv file. References to other variables are resolved
+// against the variables defined earlier in the same file first, then lookup.
+func LoadEnvFile(path string, lookup func(string) (string, bool)) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	vars, err := ParseDotenv(string(data), lookup)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return vars, nil
+}
+
+// ParseDotenv parses dotenv-formatted data. It supports comments, an optional
+// "export" prefix, single-quoted literal values, double-quoted values with
+// escapes, values spanning several lines inside quotes, and ${VAR}, $VAR and
+// ${VAR:-default} expansion in unquoted and double-quoted values.
+func ParseDotenv(data string, lookup func(string) (string, bool)) (map[string]string, error) {
+	p := &dotenvParser{
+		src:    strings.ReplaceAll(data, "\r\n", "\n"),
+		line:   1,
+		vars:   make(map[string]string),
+		lookup: lookup,
+	}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.vars, nil
+}
+
+type dotenvParser struct {
+	src    string
+	pos    int
+	line   int
+	vars   map[string]string
+	lookup func(string) (string, bool)
+}
+
+func (p *dotenvParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *dotenvParser) parse() error {
+	for p.pos < len(p.src) {
+		p.skipBlank()
+		if p.pos >= len(p.src) {
+			break
+		}
+
+		switch p.src[p.pos] {
+		case '\n':
+			p.advance(1)
+			continue
+		case '#':
+			p.skipLine()
+			continue
+		}
+
+		if strings.HasPrefix(p.src[p.pos:], "export ") || strings.HasPrefix(p.src[p.pos:], "export\t") {
+			p.advance(len("export"))
+			p.skipBlank()
+		}
+
+		start := p.pos
+		for p.pos < len(p.src) && isEnvKeyChar(p.src[p.pos], p.pos == start) {
+			p.advance(1)
+		}
+		key := p.src[start:p.pos]
+		if key == "" {
+			return p.errorf("expected variable name")
+		}
+
+		p.skipBlank()
+		if p.pos >= len(p.src) || p.src[p.pos] != '=' {
+			return p.errorf("expected '=' after %s", key)
+		}
+		p.advance(1)
+		p.skipBlank()
+
+		value, err := p.value()
+		if err != nil {
+			return err
+		}
+		p.vars[key] = value
+	}
+	return nil
+}
+
+func (p *dotenvParser) value() (string, error) {
+	if p.pos >= len(p.src) {
+		return "", nil
+	}
+
+	switch quote := p.src[p.pos]; quote {
+	case '\'':
+		p.advance(1)
+		end := strings.IndexByte(p.src[p.pos:], '\'')
+		if end < 0 {
+			return "", p.errorf("unterminated single-quoted value")
+		}
+		value := p.src[p.pos : p.pos+end]
+		p.advance(end + 1)
+		return value, p.endOfValue()
+
+	case '"':
+		p.advance(1)
+		var raw strings.Builder
+		for {
+			if p.pos >= len(p.src) {
+				return "", p.errorf("unterminated double-quoted value")
+			}
+			c := p.src[p.pos]
+			if c == '"' {
+				p.advance(1)
+				break
+			}
+			if c == '\\' && p.pos+1 < len(p.src) {
+				switch next := p.src[p.pos+1]; next {
+				case 'n':
+					raw.WriteByte('\n')
+				case 't':
+					raw.WriteByte('\t')
+				case 'r':
+					raw.WriteByte('\r')
+				case '$':
+					// keep the escape so expand leaves it literal
+					raw.WriteString(`\$`)
+				default:
+					raw.WriteByte(next)
+				}
+				p.advance(2)
+				continue
+			}
+			raw.WriteByte(c)
+			p.advance(1)
+		}
+		value, err := p.expand(raw.String())
+		if err != nil {
+			return "", err
+		}
+		return value, p.endOfValue()
+
+	default:
+		end := strings.IndexByte(p.src[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.src) - p.pos
+		}
+		raw := p.src[p.pos : p.pos+end]
+		for i := 1; i < len(raw); i++ {
+			if raw[i] == '#' && (raw[i-1] == ' ' || raw[i-1] == '\t') {
+				raw = raw[:i]
+				break
+			}
+		}
+		p.advance(end)
+		return p.expand(strings.TrimSpace(raw))
+	}
+}
+
+// endOfValue allows only whitespace or a comment after a quoted value.
+func (p *dotenvParser) endOfValue() error {
+	p.skipBlank()
+	if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+		return nil
+	}
+	if p.src[p.pos] == '#' {
+		p.skipLine()
+		return nil
+	}
+	return p.errorf("unexpected characters after quoted value")
+}
+
+func (p *dotenvParser) expand(s string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' && i+1 < len(s) && s[i+1] == '$' {
+			out.WriteByte('$')
+			i++
+			continue
+		}
+		if c != '$' || i+1 >= len(s) {
+			out.WriteByte(c)
+			continue
+		}
+
+		if s[i+1] == '{' {
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", p.errorf("unterminated ${ in %q", s)
+			}
+			name, fallback, hasFallback := strings.Cut(s[i+2:i+end], ":-")
+			if value, ok := p.resolve(name); ok && (value != "" || !hasFallback) {
+				out.WriteString(value)
+			} else {
+				out.WriteString(fallback)
+			}
+			i += end
+			continue
+		}
+
+		j := i + 1
+		for j < len(s) && isEnvKeyChar(s[j], j == i+1) && s[j] != '.' {
+			j++
+		}
+		if j == i+1 {
+			out.WriteByte(c)
+			continue
+		}
+		value, _ := p.resolve(s[i+1 : j])
+		out.WriteString(value)
+		i = j - 1
+	}
+	return out.String(), nil
+}
+
+func (p *dotenvParser) resolve(name string) (string, bool) {
+	if value, ok := p.vars[name]; ok {
+		return value, true
+	}
+	if p.lookup != nil {
+		return p.lookup(name)
+	}
+	return "", false
+}
+
+func (p *dotenvParser) advance(n int) {
+	p.line += strings.Count(p.src[p.pos:p.pos+n], "\n")
+	p.pos += n
+}
+
+func (p *dotenvParser) skipBlank() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *dotenvParser) skipLine() {
+	end := strings.IndexByte(p.src[p.pos:], '\n')
+	if end < 0 {
+		p.pos = len(p.src)
+		return
+	}
+	p.advance(end)
+}
+
+func isEnvKeyChar(c byte, first bool) bool {
+	switch {
+	case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return true
+	case !first && ((c >= '0' && c <= '9') || c == '.'):
+		return true
+	}
+	return false
+}
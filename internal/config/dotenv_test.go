@@ -0,0 +1,107 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	environ := map[string]string{"HOME": "/home/wisp", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := environ[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+		// err is the error expected instead, if any
+		err string
+	}{
+		{name: "empty", data: "", want: map[string]string{}},
+		{name: "plain", data: "PORT=8080\nHOST=localhost\n", want: map[string]string{"PORT": "8080", "HOST": "localhost"}},
+		{name: "no trailing newline", data: "PORT=8080", want: map[string]string{"PORT": "8080"}},
+		{name: "crlf", data: "PORT=8080\r\nHOST=localhost\r\n", want: map[string]string{"PORT": "8080", "HOST": "localhost"}},
+		{name: "spaces around", data: "  PORT =  8080  \n", want: map[string]string{"PORT": "8080"}},
+		{name: "empty value", data: "PORT=\n", want: map[string]string{"PORT": ""}},
+		{name: "export", data: "export PORT=8080\nexport\tHOST=localhost\n", want: map[string]string{"PORT": "8080", "HOST": "localhost"}},
+		{name: "dotted key", data: "app.port=8080\n", want: map[string]string{"app.port": "8080"}},
+		{name: "later wins", data: "PORT=1\nPORT=2\n", want: map[string]string{"PORT": "2"}},
+
+		// comments
+		{name: "comment lines", data: "# a comment\n\n  # indented\nPORT=8080\n", want: map[string]string{"PORT": "8080"}},
+		{name: "trailing comment", data: "PORT=8080 # the port\n", want: map[string]string{"PORT": "8080"}},
+		{name: "hash inside value", data: "URL=http://host/#anchor\n", want: map[string]string{"URL": "http://host/#anchor"}},
+		{name: "comment after quotes", data: `NAME="a # b" # comment` + "\n", want: map[string]string{"NAME": "a # b"}},
+
+		// single quotes
+		{name: "single quoted", data: `GREETING='hello world'`, want: map[string]string{"GREETING": "hello world"}},
+		{name: "single quoted literal", data: `RAW='$HOME \n ${HOME}'`, want: map[string]string{"RAW": `$HOME \n ${HOME}`}},
+		{name: "single quoted lines", data: "KEY='one\ntwo'\nNEXT=3", want: map[string]string{"KEY": "one\ntwo", "NEXT": "3"}},
+
+		// double quotes
+		{name: "double quoted", data: `GREETING="hello world"`, want: map[string]string{"GREETING": "hello world"}},
+		{name: "escapes", data: `ESCAPED="a\nb\tc\rd\"e\\f"`, want: map[string]string{"ESCAPED": "a\nb\tc\rd\"e\\f"}},
+		{name: "escaped dollar", data: `PRICE="\$5 and \${HOME}"`, want: map[string]string{"PRICE": "$5 and ${HOME}"}},
+		{name: "double quoted lines", data: "KEY=\"one\ntwo\"\nNEXT=3", want: map[string]string{"KEY": "one\ntwo", "NEXT": "3"}},
+		{name: "double quoted expanded", data: `DIR="${HOME}/src"`, want: map[string]string{"DIR": "/home/wisp/src"}},
+
+		// expansion
+		{name: "braced", data: "DIR=${HOME}/src", want: map[string]string{"DIR": "/home/wisp/src"}},
+		{name: "unbraced", data: "DIR=$HOME/src", want: map[string]string{"DIR": "/home/wisp/src"}},
+		{name: "earlier in the file", data: "HOST=localhost\nURL=http://$HOST:8080", want: map[string]string{"HOST": "localhost", "URL": "http://localhost:8080"}},
+		{name: "file before environment", data: "HOME=/srv\nDIR=${HOME}/src", want: map[string]string{"HOME": "/srv", "DIR": "/srv/src"}},
+		{name: "undefined", data: "DIR=${NOPE}/src", want: map[string]string{"DIR": "/src"}},
+		{name: "fallback", data: "PORT=${NOPE:-8080}", want: map[string]string{"PORT": "8080"}},
+		{name: "fallback for empty", data: "PORT=${EMPTY:-8080}", want: map[string]string{"PORT": "8080"}},
+		{name: "fallback unused", data: "DIR=${HOME:-/tmp}", want: map[string]string{"DIR": "/home/wisp"}},
+		{name: "unbraced stops at dot", data: "FILE=$HOME.txt", want: map[string]string{"FILE": "/home/wisp.txt"}},
+		{name: "lone dollar", data: "PRICE=5$", want: map[string]string{"PRICE": "5$"}},
+		{name: "dollar before digit", data: "PRICE=$5", want: map[string]string{"PRICE": "$5"}},
+
+		// errors
+		{name: "unterminated single quote", data: "A=1\nKEY='oops\n", err: "2: unterminated single-quoted value"},
+		{name: "unterminated double quote", data: `KEY="oops`, err: "1: unterminated double-quoted value"},
+		{name: "text after quotes", data: `KEY="a" b`, err: "1: unexpected characters after quoted value"},
+		{name: "missing equals", data: "A=1\n\nKEY value\n", err: "3: expected '=' after KEY"},
+		{name: "missing name", data: "=value", err: "1: expected variable name"},
+		{name: "unterminated reference", data: "KEY=${HOME", err: `1: unterminated ${ in "${HOME"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDotenv(tt.data, lookup)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("ParseDotenv(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadEnvFileError(t *testing.T) {
+	if _, err := LoadEnvFile("/nonexistent/.env", nil); err == nil || !strings.Contains(err.Error(), "failed to read env file") {
+		t.Errorf("error = %v, want a read error", err)
+	}
+
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("PORT=8080\nKEY value\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := path + ":2: expected '=' after KEY"
+	if _, err := LoadEnvFile(path, nil); err == nil || err.Error() != want {
+		t.Errorf("error = %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,356 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// InterpolationError reports a ${...} reference that could not be expanded.
+// Key is the path of the offending value, starting with the app name.
+type InterpolationError struct {
+	Key []string
+	Err error
+}
+
+func (e *InterpolationError) Error() string {
+	return fmt.Sprintf("%s: %v", strings.Join(e.Key, "."), e.Err)
+}
+
+func (e *InterpolationError) Unwrap() error {
+	return e.Err
+}
+
+// interpolator expands ${...} references in app settings. The supported
+// variables are:
+//
+//	${session_dir}         the directory of the current wisp session
+//	${config_dir}          the directory containing the configuration file
+//	${app.<field>}         a field of the app being expanded, e.g. ${app.name}
+//	${apps.<name>.<field>} a field of another app, e.g. ${apps.api.env.PORT}
+//	${env.<NAME>}          a variable from wisp's environment
+//	${git.sha}             the current commit (also git.short_sha, git.branch)
+//
+// Any reference may carry a fallback, ${env.PORT:-8080}, and $${ produces a
+// literal ${. References to other names, such as ${HOME} or ${PORT:-8080},
+// are left as they are for the shell running the command to expand.
+type interpolator struct {
+	apps      map[string]*App
+	vars      map[string]string
+	configDir string
+	git       map[string]string
+	resolved  map[string]string
+	resolving map[string]bool
+	errs      []*InterpolationError
+}
+
+func newInterpolator(apps map[string]*App, vars map[string]string, configDir string) *interpolator {
+	return &interpolator{
+		apps:      apps,
+		vars:      vars,
+		configDir: configDir,
+		resolved:  make(map[string]string),
+		resolving: make(map[string]bool),
+	}
+}
+
+// interpolate returns copies of the apps with every string setting expanded,
+// along with every reference that could not be resolved.
+func interpolate(apps map[string]*App, vars map[string]string, configDir string) (map[string]*App, []*InterpolationError) {
+	in := newInterpolator(apps, vars, configDir)
+
+	expanded := make(map[string]*App, len(apps))
+	for name, app := range apps {
+		value := in.expandValue(name, []string{name}, reflect.ValueOf(app).Elem())
+		expandedApp := value.Interface().(App)
+		expanded[name] = &expandedApp
+	}
+
+	return expanded, in.errs
+}
+
+// expandValue returns a deep copy of v with every string inside expanded.
+func (in *interpolator) expandValue(appName string, key []string, v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := in.expand(appName, v.String())
+		if err != nil {
+			in.errs = append(in.errs, &InterpolationError{Key: append([]string{}, key...), Err: err})
+			return v
+		}
+		return reflect.ValueOf(s).Convert(v.Type())
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(in.expandValue(appName, append(key, strconv.Itoa(i)), v.Index(i)))
+		}
+		return out
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), in.expandValue(appName, append(key, fmt.Sprint(iter.Key())), iter.Value()))
+		}
+		return out
+
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldPath := append(key, fieldKey(field))
+			if isInline(field) {
+				fieldPath = key
+			}
+			out.Field(i).Set(in.expandValue(appName, fieldPath, v.Field(i)))
+		}
+		return out
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(in.expandValue(appName, key, v.Elem()))
+		return out
+	}
+
+	return v
+}
+
+func (in *interpolator) expand(appName, s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "$${") {
+			out.WriteString("${")
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(s[i:], "${") {
+			out.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", s)
+		}
+		value, err := in.lookup(appName, s[i+2:i+end])
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(value)
+		i += end + 1
+	}
+
+	return out.String(), nil
+}
+
+func (in *interpolator) lookup(appName, expr string) (string, error) {
+	name, fallback, hasFallback := strings.Cut(expr, ":-")
+	name = strings.TrimSpace(name)
+	if !isVariable(name) {
+		return "${" + expr + "}", nil
+	}
+
+	value, found, err := in.resolve(appName, name)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return value, nil
+	}
+	if hasFallback {
+		return fallback, nil
+	}
+	return "", fmt.Errorf("undefined variable ${%s}", name)
+}
+
+// isVariable reports whether name is one of wisp's variables rather than one
+// meant for the shell.
+func isVariable(name string) bool {
+	switch namespace, rest, _ := strings.Cut(name, "."); namespace {
+	case "session_dir", "config_dir":
+		return rest == ""
+	case "env", "git", "app", "apps":
+		return true
+	}
+	return false
+}
+
+func (in *interpolator) resolve(appName, name string) (string, bool, error) {
+	if value, ok := in.vars[name]; ok {
+		return value, true, nil
+	}
+
+	namespace, rest, _ := strings.Cut(name, ".")
+	switch {
+	case name == "config_dir":
+		return in.configDir, true, nil
+	case namespace == "env" && rest != "":
+		value, ok := os.LookupEnv(rest)
+		return value, ok, nil
+	case namespace == "git" && rest != "":
+		return in.gitValue(rest)
+	case namespace == "app" && rest != "":
+		return in.appField(appName, strings.Split(rest, "."))
+	case namespace == "apps" && rest != "":
+		target, path, ok := strings.Cut(rest, ".")
+		if !ok {
+			return "", false, fmt.Errorf("${%s} must name a field, e.g. ${apps.%s.bin}", name, target)
+		}
+		if _, exists := in.apps[target]; !exists {
+			return "", false, fmt.Errorf("${%s} refers to unknown app '%s'", name, target)
+		}
+		return in.appField(target, strings.Split(path, "."))
+	}
+
+	return "", false, nil
+}
+
+// appField looks up a field of an app by its TOML key path and expands it in
+// the context of that app.
+func (in *interpolator) appField(appName string, path []string) (string, bool, error) {
+	id := appName + "." + strings.Join(path, ".")
+	if value, ok := in.resolved[id]; ok {
+		return value, true, nil
+	}
+	if in.resolving[id] {
+		return "", false, fmt.Errorf("reference cycle through ${apps.%s}", id)
+	}
+
+	app := in.apps[appName]
+	v := reflect.ValueOf(app).Elem()
+	for _, part := range path {
+		var ok bool
+		if v, ok = fieldByKey(v, part); !ok {
+			return "", false, nil
+		}
+	}
+
+	if cmd, ok := v.Interface().(Command); ok && len(cmd.Argv) == 0 {
+		v = reflect.ValueOf(cmd.Line)
+	}
+
+	var raw string
+	switch v.Kind() {
+	case reflect.String:
+		raw = v.String()
+	case reflect.Bool, reflect.Int, reflect.Int64:
+		return fmt.Sprint(v.Interface()), true, nil
+	default:
+		return "", false, fmt.Errorf("${apps.%s} is not a single value", id)
+	}
+
+	in.resolving[id] = true
+	value, err := in.expand(appName, raw)
+	delete(in.resolving, id)
+	if err != nil {
+		return "", false, fmt.Errorf("via ${apps.%s}: %w", id, err)
+	}
+
+	in.resolved[id] = value
+	return value, true, nil
+}
+
+func (in *interpolator) gitValue(name string) (string, bool, error) {
+	args := map[string][]string{
+		"sha":       {"rev-parse", "HEAD"},
+		"short_sha": {"rev-parse", "--short", "HEAD"},
+		"branch":    {"rev-parse", "--abbrev-ref", "HEAD"},
+	}[name]
+	if args == nil {
+		return "", false, nil
+	}
+
+	if value, ok := in.git[name]; ok {
+		return value, true, nil
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = in.configDir
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			err = fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", false, fmt.Errorf("${git.%s}: %w", name, err)
+	}
+
+	if in.git == nil {
+		in.git = make(map[string]string)
+	}
+	in.git[name] = strings.TrimSpace(string(output))
+	return in.git[name], true, nil
+}
+
+// fieldByKey steps into a struct field by TOML key, a map by key, or a slice
+// by index.
+func fieldByKey(v reflect.Value, key string) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if isInline(field) {
+				if value, ok := fieldByKey(v.Field(i), key); ok {
+					return value, true
+				}
+			} else if fieldKey(field) == key {
+				return v.Field(i), true
+			}
+		}
+	case reflect.Map:
+		value := v.MapIndex(reflect.ValueOf(key))
+		return value, value.IsValid()
+	case reflect.Slice:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < v.Len() {
+			return v.Index(i), true
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+// fieldKey returns the TOML key of a struct field, or its lowercased name
+// for fields without a tag such as App.Name.
+func fieldKey(field reflect.StructField) string {
+	if tag, _, _ := strings.Cut(field.Tag.Get("toml"), ","); tag != "" {
+		return tag
+	}
+	return strings.ToLower(field.Name)
+}
+
+// isInline reports whether a struct field shares the TOML key of the struct
+// it is in, as the fields of Command do.
+func isInline(field reflect.StructField) bool {
+	_, opts, _ := strings.Cut(field.Tag.Get("toml"), ",")
+	return opts == "inline"
+}
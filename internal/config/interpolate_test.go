@@ -0,0 +1,120 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("WISP_TEST_PORT", "9090")
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		// err is part of the error expected instead, if any
+		err string
+	}{
+		{name: "plain", value: "go build .", want: "go build ."},
+		{name: "session dir", value: "${session_dir}/api", want: "/tmp/session/api"},
+		{name: "config dir", value: "${config_dir}/bin", want: "/srv/app/bin"},
+		{name: "own field", value: "bin/${app.name}", want: "bin/api"},
+		{name: "own map field", value: ":${app.env.PORT}", want: ":8080"},
+		{name: "other app", value: "http://localhost:${apps.worker.env.PORT}", want: "http://localhost:7070"},
+		{name: "other app expanded in its context", value: "${apps.worker.bin}", want: "/tmp/session/worker"},
+		{name: "environment", value: "${env.WISP_TEST_PORT}", want: "9090"},
+		{name: "fallback unused", value: "${env.WISP_TEST_PORT:-80}", want: "9090"},
+		{name: "fallback", value: "${env.WISP_TEST_UNSET:-80}", want: "80"},
+		{name: "empty fallback", value: "[${env.WISP_TEST_UNSET:-}]", want: "[]"},
+		{name: "spaces around the name", value: "${ app.name }", want: "api"},
+
+		// left for the shell
+		{name: "shell variable", value: "echo ${HOME}", want: "echo ${HOME}"},
+		{name: "shell variable with fallback", value: "serve -p ${PORT:-8080}", want: "serve -p ${PORT:-8080}"},
+		{name: "shell variable nested", value: "${A:-${B}}", want: "${A:-${B}}"},
+		{name: "shell variable unbraced", value: "echo $HOME", want: "echo $HOME"},
+		{name: "shell and wisp variables", value: "${HOME}/${app.name}", want: "${HOME}/api"},
+
+		// escaping
+		{name: "escaped", value: "echo $${app.name}", want: "echo ${app.name}"},
+		{name: "escaped then expanded", value: "$${app.name}=${app.name}", want: "${app.name}=api"},
+		{name: "escaped shell variable", value: "$${HOME}", want: "${HOME}"},
+		{name: "lone dollar", value: "cost $5", want: "cost $5"},
+
+		// errors
+		{name: "undefined environment variable", value: "${env.WISP_TEST_UNSET}", err: "undefined variable ${env.WISP_TEST_UNSET}"},
+		{name: "unknown field", value: "${app.nope}", err: "undefined variable ${app.nope}"},
+		{name: "unknown app", value: "${apps.nope.bin}", err: "unknown app 'nope'"},
+		{name: "app without field", value: "${apps.worker}", err: "must name a field"},
+		{name: "not a single value", value: "${app.env}", err: "is not a single value"},
+		{name: "unterminated", value: "${app.name", err: "unterminated reference"},
+		{name: "cycle", value: "${apps.loop.tmp_dir}", err: "reference cycle"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apps := map[string]*App{
+				"api": {
+					Name:   "api",
+					Env:    map[string]string{"PORT": "8080"},
+					RunCmd: Command{Line: tt.value},
+				},
+				"worker": {
+					Name: "worker",
+					Bin:  "${session_dir}/${app.name}",
+					Env:  map[string]string{"PORT": "7070"},
+				},
+				"loop": {
+					Name:       "loop",
+					TmpDir:     "${app.working_dir}",
+					WorkingDir: "${app.tmp_dir}",
+				},
+			}
+			vars := map[string]string{"session_dir": "/tmp/session"}
+
+			expanded, errs := interpolate(apps, vars, "/srv/app")
+			var apiErrs []*InterpolationError
+			for _, err := range errs {
+				if err.Key[0] == "api" {
+					apiErrs = append(apiErrs, err)
+				}
+			}
+
+			if tt.err != "" {
+				if len(apiErrs) != 1 || !strings.Contains(apiErrs[0].Error(), tt.err) {
+					t.Fatalf("errors = %v, want one containing %q", apiErrs, tt.err)
+				}
+				if key := strings.Join(apiErrs[0].Key, "."); key != "api.run_cmd" {
+					t.Errorf("error key = %s, want api.run_cmd", key)
+				}
+				return
+			}
+			if len(apiErrs) > 0 {
+				t.Fatalf("unexpected errors: %v", apiErrs)
+			}
+			if got := expanded["api"].RunCmd.Line; got != tt.want {
+				t.Errorf("%q expanded to %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateArgv(t *testing.T) {
+	apps := map[string]*App{
+		"api": {
+			Name:   "api",
+			RunCmd: Command{Argv: []string{"./${app.name}", "--home=${HOME}", "$${app.name}"}},
+		},
+	}
+
+	expanded, errs := interpolate(apps, nil, "/srv/app")
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	want := []string{"./api", "--home=${HOME}", "${app.name}"}
+	got := expanded["api"].RunCmd.Argv
+	if !slices.Equal(got, want) {
+		t.Errorf("argv expanded to %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ConfigFiles expands the configuration files given on the command line with
+// their local override files. For every file such as wisp.toml, a sibling
+// wisp.local.toml is loaded if it exists. Local files are layered after all
+// of the shared ones so personal tweaks always win.
+func ConfigFiles(paths []string) []string {
+	if len(paths) == 0 {
+		paths = []string{"wisp.toml"}
+	}
+
+	listed := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		listed[filepath.Clean(path)] = true
+	}
+
+	files := append([]string{}, paths...)
+	for _, path := range paths {
+		local := LocalOverridePath(path)
+		if local == "" || listed[local] {
+			continue
+		}
+		if _, err := os.Stat(local); err == nil {
+			files = append(files, local)
+			listed[local] = true
+		}
+	}
+
+	return files
+}
+
+// LocalOverridePath returns the local override file for path, e.g.
+// wisp.local.toml for wisp.toml, or "" if path already is one.
+func LocalOverridePath(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	if strings.HasSuffix(base, ".local") {
+		return ""
+	}
+	return filepath.Clean(base + ".local" + ext)
+}
+
+// document is a single parsed configuration file.
+type document struct {
+	path      string
+	raw       map[string]interface{}
+	md        toml.MetaData
+	positions map[string]position
+}
+
+func readDocument(path string) (*document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("configuration file %s not found", path)
+		}
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	doc := &document{
+		path:      path,
+		positions: keyPositions(string(data)),
+	}
+
+	md, err := toml.Decode(string(data), &doc.raw)
+	if err != nil {
+		return nil, err
+	}
+	doc.md = md
+
+	return doc, nil
+}
+
+// source returns where key is defined in the document, falling back to the
+// closest enclosing key that could be located.
+func (d *document) source(key ...string) source {
+	for n := len(key); n > 0; n-- {
+		if pos, ok := d.positions[strings.Join(key[:n], ".")]; ok {
+			return source{file: d.path, pos: pos}
+		}
+	}
+	return source{file: d.path, pos: position{line: 1, col: 1}}
+}
+
+// source records the file and position a setting was read from.
+type source struct {
+	file string
+	pos  position
+}
+
+func (s source) String() string {
+	return fmt.Sprintf("%s:%d", s.file, s.pos.line)
+}
+
+// sourceOf looks up the source of key, falling back to its closest parent.
+func sourceOf(sources map[string]source, key ...string) (source, bool) {
+	for n := len(key); n > 0; n-- {
+		if src, ok := sources[strings.Join(key[:n], ".")]; ok {
+			return src, true
+		}
+	}
+	return source{}, false
+}
+
+// profilesTable is the reserved top-level table holding named profiles.
+const profilesTable = "profile"
+
+// layerDocuments merges the documents in order, later files overriding
+// earlier ones. When a profile is selected, each file's [profile.<name>]
+// tables are layered right after that file. Tables are merged key by key
+// while any other value, lists included, is replaced. The returned sources
+// record which file each value was taken from, keyed by its dotted path.
+func layerDocuments(docs []*document, profile string) (map[string]interface{}, map[string]source, error) {
+	raw := make(map[string]interface{})
+	sources := make(map[string]source)
+	found := profile == ""
+	var available []string
+
+	for _, doc := range docs {
+		base := make(map[string]interface{}, len(doc.raw))
+		for key, value := range doc.raw {
+			if key != profilesTable {
+				base[key] = value
+			}
+		}
+		raw = layerValues(raw, base, nil, doc.locator(nil), sources)
+
+		profiles, _ := doc.raw[profilesTable].(map[string]interface{})
+		for name := range profiles {
+			available = append(available, name)
+		}
+		if table, ok := profiles[profile].(map[string]interface{}); ok && profile != "" {
+			raw = layerValues(raw, table, nil, doc.locator([]string{profilesTable, profile}), sources)
+			found = true
+		}
+	}
+
+	if !found {
+		if len(available) == 0 {
+			return nil, nil, fmt.Errorf("profile '%s' is not defined, the configuration has no profiles", profile)
+		}
+		sort.Strings(available)
+		return nil, nil, fmt.Errorf("profile '%s' is not defined (available: %s)", profile, strings.Join(slices.Compact(available), ", "))
+	}
+
+	return raw, sources, nil
+}
+
+// locator maps a key path to its position in the document, relative to the
+// table at prefix.
+func (d *document) locator(prefix []string) func(path []string) source {
+	return func(path []string) source {
+		return d.source(append(append([]string{}, prefix...), path...)...)
+	}
+}
+
+func layerValues(base, override map[string]interface{}, prefix []string, locate func([]string) source, sources map[string]source) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range override {
+		path := append(append([]string{}, prefix...), key)
+
+		if ov, ok := value.(map[string]interface{}); ok {
+			if bv, ok := merged[key].(map[string]interface{}); ok {
+				merged[key] = layerValues(bv, ov, path, locate, sources)
+				continue
+			}
+		}
+
+		merged[key] = value
+		recordSources(value, path, locate, sources)
+	}
+
+	return merged
+}
+
+func recordSources(value interface{}, path []string, locate func([]string) source, sources map[string]source) {
+	sources[strings.Join(path, ".")] = locate(path)
+
+	if table, ok := value.(map[string]interface{}); ok {
+		for key, item := range table {
+			recordSources(item, append(append([]string{}, path...), key), locate, sources)
+		}
+	}
+	if tables, ok := value.([]map[string]interface{}); ok {
+		for i, table := range tables {
+			recordSources(table, append(append([]string{}, path...), strconv.Itoa(i)), locate, sources)
+		}
+	}
+}
+
+// inheritSources records, for every value an app inherited from the shared
+// defaults table, the source of the inherited value.
+func inheritSources(appName, defaultsName string, merged map[string]interface{}, prefix []string, sources map[string]source) {
+	for key, value := range merged {
+		path := append(append([]string{}, prefix...), key)
+		appKey := appName + "." + strings.Join(path, ".")
+
+		if _, ok := sources[appKey]; !ok && defaultsName != "" {
+			if src, ok := sources[defaultsName+"."+strings.Join(path, ".")]; ok {
+				sources[appKey] = src
+			}
+		}
+
+		if table, ok := value.(map[string]interface{}); ok {
+			inheritSources(appName, defaultsName, table, path, sources)
+		}
+		if tables, ok := value.([]map[string]interface{}); ok {
+			for i, table := range tables {
+				inheritSources(appName, defaultsName, table, append(path, strconv.Itoa(i)), sources)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// Actions an on_change rule can take, from the weakest to the strongest.
+const (
+	ActionIgnore  = "ignore"
+	ActionRun     = "run"
+	ActionSignal  = "signal"
+	ActionRestart = "restart"
+	ActionRebuild = "rebuild"
+)
+
+// ChangeRule sets what a change to the files matching Paths does, in place
+// of a full rebuild. Paths are glob patterns relative to the working
+// directory; a pattern without a slash matches a file name at any depth.
+type ChangeRule struct {
+	Paths  []string `toml:"paths"`
+	Action string   `toml:"action"`
+	// Signal is sent to the app by the "signal" action, e.g. "SIGHUP".
+	Signal string `toml:"signal"`
+	// Run is the command run by the "run" action.
+	Run Command `toml:"run"`
+}
+
+// signals are the signals an on_change rule may send, by name.
+var signals = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"TERM":  syscall.SIGTERM,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// ParseSignal returns the signal with the given name, such as "SIGHUP" or
+// "HUP".
+func ParseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+// tableList returns the tables of an array of tables.
+func tableList(value interface{}) []map[string]interface{} {
+	switch v := value.(type) {
+	case []map[string]interface{}:
+		return v
+	case []interface{}:
+		tables := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			if table, ok := item.(map[string]interface{}); ok {
+				tables = append(tables, table)
+			}
+		}
+		return tables
+	}
+	return nil
+}
+
+func parseChangeRules(value interface{}) []ChangeRule {
+	var rules []ChangeRule
+	for _, table := range tableList(value) {
+		rule := ChangeRule{
+			Paths:  stringOrList(table["paths"]),
+			Action: ActionRebuild,
+			Run:    parseCommand(table["run"]),
+		}
+		if action, ok := table["action"].(string); ok {
+			rule.Action = action
+		}
+		if signal, ok := table["signal"].(string); ok {
+			rule.Signal = signal
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteEffective writes the effective settings of every app, after layering,
+// defaults and variable expansion, annotating each value with where it was
+// defined.
+func (c *Config) WriteEffective(w io.Writer) error {
+	names := make([]string, 0, len(c.Apps))
+	for name := range c.Apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	header := fmt.Sprintf("# Effective configuration from %s", strings.Join(c.Files, ", "))
+	if c.Profile != "" {
+		header += fmt.Sprintf(" with profile '%s'", c.Profile)
+	}
+	if _, err := fmt.Fprintln(w, header); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		var lines []effectiveLine
+		app := reflect.ValueOf(c.Apps[name]).Elem()
+		for i := 0; i < app.NumField(); i++ {
+			field := app.Type().Field(i)
+			if field.Name == "Name" {
+				continue
+			}
+			lines = c.collectLines(lines, []string{name, fieldKey(field)}, app.Field(i))
+		}
+
+		width := 0
+		for _, line := range lines {
+			width = max(width, len(line.setting))
+		}
+
+		if _, err := fmt.Fprintf(w, "\n[%s]\n", name); err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintf(w, "  %-*s  # %s\n", width, line.setting, line.origin); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type effectiveLine struct {
+	setting string
+	origin  string
+}
+
+// collectLines flattens a setting into dotted key = value lines. Settings
+// that were never written down and still hold their zero value are omitted.
+func (c *Config) collectLines(lines []effectiveLine, key []string, v reflect.Value) []effectiveLine {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return lines
+		}
+		v = v.Elem()
+	}
+
+	_, isCommand := v.Interface().(Command)
+
+	switch {
+	case isCommand:
+	case v.Kind() == reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			lines = c.collectLines(lines, append(key, k), v.MapIndex(reflect.ValueOf(k)))
+		}
+		return lines
+
+	case v.Kind() == reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Type().Field(i); field.IsExported() {
+				lines = c.collectLines(lines, append(key, fieldKey(field)), v.Field(i))
+			}
+		}
+		return lines
+
+	case v.Kind() == reflect.Slice:
+		if elem := v.Type().Elem(); elem.Kind() == reflect.Ptr || (elem.Kind() == reflect.Struct && elem != reflect.TypeOf(Command{})) {
+			for i := 0; i < v.Len(); i++ {
+				lines = c.collectLines(lines, append(key, strconv.Itoa(i)), v.Index(i))
+			}
+			return lines
+		}
+	}
+
+	origin := c.Source(key...)
+	if origin == "" {
+		if v.IsZero() {
+			return lines
+		}
+		origin = "built-in default"
+	}
+
+	return append(lines, effectiveLine{
+		setting: fmt.Sprintf("%s = %s", strings.Join(key[1:], "."), formatValue(v)),
+		origin:  origin,
+	})
+}
+
+func formatValue(v reflect.Value) string {
+	if cmd, ok := v.Interface().(Command); ok {
+		if len(cmd.Argv) > 0 {
+			return formatValue(reflect.ValueOf(cmd.Argv))
+		}
+		return strconv.Quote(cmd.Line)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatValue(v.Index(i))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
@@ -0,0 +1,902 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/mktcz/wisp/internal/glob"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Diagnostic is a single problem found while validating a configuration file.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Column   int
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+// HasErrors reports whether any of the diagnostics is an error rather than a
+// warning.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindInt
+	kindBool
+	kindStringList
+	kindStringMap
+	kindStringOrList
+	kindCommandList
+	kindTable
+	kindTableList
+)
+
+func (k fieldKind) String() string {
+	switch k {
+	case kindInt:
+		return "an integer"
+	case kindBool:
+		return "a boolean"
+	case kindStringList:
+		return "an array of strings"
+	case kindStringMap:
+		return "a table of strings"
+	case kindStringOrList:
+		return "a string or an array of strings"
+	case kindCommandList:
+		return "an array of strings or of arrays of strings"
+	case kindTable:
+		return "a table"
+	case kindTableList:
+		return "an array of tables"
+	default:
+		return "a string"
+	}
+}
+
+// appFields lists every key accepted in an app table (and in the shared
+// defaults table) together with the type it must have.
+var appFields = map[string]fieldKind{
+	"run_cmd":                   kindStringOrList,
+	"build_cmd":                 kindStringOrList,
+	"cmd":                       kindStringOrList,
+	"shell":                     kindStringList,
+	"bin":                       kindString,
+	"args":                      kindStringList,
+	"watch_dir":                 kindStringOrList,
+	"working_dir":               kindString,
+	"tmp_dir":                   kindString,
+	"env":                       kindStringMap,
+	"env_file":                  kindStringOrList,
+	"delay":                     kindInt,
+	"kill_delay":                kindString,
+	"stop_timeout":              kindString,
+	"rerun":                     kindBool,
+	"rerun_delay":               kindInt,
+	"exclude_dir":               kindStringList,
+	"exclude_file":              kindStringList,
+	"exclude_regex":             kindStringList,
+	"include_ext":               kindStringList,
+	"include_file":              kindStringList,
+	"watch_go_deps":             kindBool,
+	"go_package":                kindString,
+	"follow_symlink":            kindBool,
+	"use_gitignore":             kindBool,
+	"watch_mode":                kindString,
+	"poll_interval":             kindInt,
+	"poll_hash":                 kindBool,
+	"ignore_unchanged":          kindBool,
+	"wait_for_git":              kindBool,
+	"pre_cmd":                   kindCommandList,
+	"post_cmd":                  kindCommandList,
+	"send_interrupt":            kindBool,
+	"stop_on_error":             kindBool,
+	"log_silent":                kindBool,
+	"clean_on_exit":             kindBool,
+	"debounce":                  kindInt,
+	"stop_delay":                kindInt,
+	"start_delay":               kindInt,
+	"enabled":                   kindBool,
+	"list_merge":                kindString,
+	"depends_on":                kindStringList,
+	"restart_with_dependencies": kindBool,
+	"ready":                     kindTable,
+	"restart":                   kindString,
+	"max_restarts":              kindInt,
+	"restart_backoff":           kindString,
+	"crash_limit":               kindInt,
+	"crash_window":              kindString,
+	"liveness":                  kindTable,
+	"build_timeout":             kindString,
+	"build_swap":                kindBool,
+	"skip_unchanged":            kindBool,
+	"on_change":                 kindTableList,
+}
+
+// tableFields lists the keys accepted in the app settings that are tables
+// of their own.
+var tableFields = map[string]map[string]fieldKind{
+	"ready": {
+		"http":     kindString,
+		"status":   kindInt,
+		"tcp":      kindString,
+		"log":      kindString,
+		"file":     kindString,
+		"interval": kindString,
+		"timeout":  kindString,
+	},
+	"liveness": {
+		"http":              kindString,
+		"status":            kindInt,
+		"tcp":               kindString,
+		"exec":              kindStringOrList,
+		"interval":          kindString,
+		"timeout":           kindString,
+		"failure_threshold": kindInt,
+		"goroutine_dump":    kindBool,
+		"dump_dir":          kindString,
+	},
+	"on_change": {
+		"paths":  kindStringOrList,
+		"action": kindString,
+		"signal": kindString,
+		"run":    kindStringOrList,
+	},
+}
+
+// Validate checks the given configuration files, along with their local
+// override files, and returns every problem it finds, each positioned at the
+// offending key. Keys and types are checked per file; settings that only
+// make sense once the files are layered, such as a missing run command, are
+// checked on the merged result, with the profile from opts applied. The
+// returned error is only non-nil when a file cannot be read at all.
+func Validate(configPaths []string, opts Options) ([]Diagnostic, error) {
+	files := ConfigFiles(configPaths)
+	v := &validator{opts: opts}
+
+	var docs []*document
+	for _, path := range files {
+		doc, err := readDocument(path)
+		if err != nil {
+			var parseErr toml.ParseError
+			if errors.As(err, &parseErr) {
+				v.diagnostics = append(v.diagnostics, Diagnostic{
+					File:     path,
+					Line:     parseErr.Position.Line,
+					Column:   parseErr.Position.Col,
+					Severity: SeverityError,
+					Message:  parseErr.Message,
+				})
+				continue
+			}
+			return nil, err
+		}
+
+		v.doc = doc
+		v.checkDocument()
+		docs = append(docs, doc)
+	}
+
+	if len(docs) == len(files) {
+		v.checkMerged(docs)
+	}
+
+	order := make(map[string]int, len(files))
+	for i, path := range files {
+		order[path] = i
+	}
+	sort.SliceStable(v.diagnostics, func(i, j int) bool {
+		a, b := v.diagnostics[i], v.diagnostics[j]
+		if a.File != b.File {
+			return order[a.File] < order[b.File]
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	return v.diagnostics, nil
+}
+
+type validator struct {
+	opts             Options
+	doc              *document
+	sources          map[string]source
+	defaultsConflict bool
+	diagnostics      []Diagnostic
+}
+
+// checkDocument validates the tables written in a single file.
+func (v *validator) checkDocument() {
+	if _, _, err := defaultsTable(v.doc.raw); err != nil {
+		v.report(SeverityError, err.Error(), defaultsTables[len(defaultsTables)-1])
+		v.defaultsConflict = true
+	}
+
+	for _, name := range sortedKeys(v.doc.raw) {
+		table, ok := v.doc.raw[name].(map[string]interface{})
+		if !ok {
+			v.report(SeverityError, fmt.Sprintf("top-level key '%s' is not an app table", name), name)
+			continue
+		}
+
+		if name == profilesTable {
+			v.checkProfiles(table)
+			continue
+		}
+
+		v.checkTable([]string{name}, table)
+	}
+}
+
+// checkProfiles validates [profile.<name>.<app>] tables, which accept the
+// same keys as the tables they override.
+func (v *validator) checkProfiles(profiles map[string]interface{}) {
+	for _, profile := range sortedKeys(profiles) {
+		tables, ok := profiles[profile].(map[string]interface{})
+		if !ok {
+			v.report(SeverityError, fmt.Sprintf("profile '%s' must be a table", profile), profilesTable, profile)
+			continue
+		}
+
+		if _, _, err := defaultsTable(tables); err != nil {
+			v.report(SeverityError, err.Error(), profilesTable, profile)
+		}
+
+		for _, name := range sortedKeys(tables) {
+			table, ok := tables[name].(map[string]interface{})
+			if !ok {
+				v.report(SeverityError, fmt.Sprintf("'%s' in profile '%s' is not an app table", name, profile), profilesTable, profile, name)
+				continue
+			}
+			v.checkTable([]string{profilesTable, profile, name}, table)
+		}
+	}
+}
+
+// checkMerged validates the apps that result from layering every file.
+func (v *validator) checkMerged(docs []*document) {
+	rawConfig, sources, err := layerDocuments(docs, v.opts.Profile)
+	if err != nil {
+		v.doc = docs[0]
+		v.report(SeverityError, err.Error())
+		return
+	}
+	v.sources = sources
+	v.checkProfileApps(docs)
+
+	defaults, defaultsName, err := defaultsTable(rawConfig)
+	if err != nil {
+		// a conflict within a single file has already been reported
+		if !v.defaultsConflict {
+			v.reportKey(SeverityError, err.Error(), defaultsTables[len(defaultsTables)-1])
+		}
+		defaults = nil
+	}
+
+	apps := make(map[string]*App)
+	var names []string
+	for name, value := range rawConfig {
+		table, ok := value.(map[string]interface{})
+		if !ok || isDefaultsTable(name) {
+			continue
+		}
+
+		merged, err := mergeTables(defaults, table)
+		if err != nil {
+			// already reported by checkTable on the offending table
+			continue
+		}
+		apps[name] = parseApp(name, merged)
+		inheritSources(name, defaultsName, merged, nil, sources)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// no session may exist while validating, so use a representative path
+	vars := runtimeVars(v.opts)
+	if _, ok := vars["session_dir"]; !ok {
+		vars["session_dir"] = "/tmp/wisp/session"
+	}
+	dir := configDir(docs[0].path)
+	expanded, errs := interpolate(apps, vars, dir)
+	for _, err := range errs {
+		v.reportKey(SeverityError, err.Err.Error(), err.Key...)
+	}
+	for _, app := range expanded {
+		resolvePaths(app, dir, sources)
+	}
+
+	for _, name := range names {
+		v.checkApp(expanded[name])
+		if err := unknownDependency(expanded, expanded[name]); err != nil {
+			v.reportKey(SeverityError, err.Error(), name, "depends_on")
+		}
+	}
+
+	if cycle := dependencyCycle(expanded); cycle != nil {
+		v.reportKey(SeverityError, fmt.Sprintf("dependency cycle %s", strings.Join(cycle, " -> ")), cycle[0], "depends_on")
+	}
+}
+
+// checkProfileApps warns about profiles that configure apps which are not
+// defined outside of any profile, usually a misspelt app name.
+func (v *validator) checkProfileApps(docs []*document) {
+	defined := make(map[string]bool)
+	for _, doc := range docs {
+		for name := range doc.raw {
+			defined[name] = true
+		}
+	}
+
+	for _, doc := range docs {
+		v.doc = doc
+		profiles, _ := doc.raw[profilesTable].(map[string]interface{})
+		for _, profile := range sortedKeys(profiles) {
+			tables, _ := profiles[profile].(map[string]interface{})
+			for _, name := range sortedKeys(tables) {
+				if !defined[name] && !isDefaultsTable(name) {
+					v.report(SeverityWarning, fmt.Sprintf("profile '%s' configures app '%s', which is not defined outside the profile", profile, name), profilesTable, profile, name)
+				}
+			}
+		}
+	}
+}
+
+// checkTable validates the keys written directly in the table at path.
+func (v *validator) checkTable(path []string, values map[string]interface{}) {
+	v.checkFields(path, values, appFields)
+}
+
+// checkFields validates values against the given schema, descending into
+// the settings that are tables.
+func (v *validator) checkFields(path []string, values map[string]interface{}, fields map[string]fieldKind) {
+	table := strings.Join(path, ".")
+	at := func(key string) []string {
+		return append(append([]string{}, path...), key)
+	}
+
+	for _, key := range sortedKeys(values) {
+		value := values[key]
+		kind, known := fields[key]
+		if !known {
+			msg := fmt.Sprintf("unknown key '%s' in [%s]", key, table)
+			if suggestion := closestField(key, fields); suggestion != "" {
+				msg += fmt.Sprintf(" (did you mean '%s'?)", suggestion)
+			}
+			v.report(SeverityError, msg, at(key)...)
+			continue
+		}
+
+		if !hasKind(value, kind) {
+			v.report(SeverityError, fmt.Sprintf("'%s' must be %s, got %s", key, kind, v.typeName(value, at(key)...)), at(key)...)
+			continue
+		}
+
+		if kind == kindTable {
+			v.checkFields(at(key), value.(map[string]interface{}), tableFields[key])
+			continue
+		}
+		if kind == kindTableList {
+			for i, table := range tableList(value) {
+				v.checkFields(append(at(key), strconv.Itoa(i)), table, tableFields[key])
+			}
+			continue
+		}
+
+		switch key {
+		case "interval", "timeout", "restart_backoff", "crash_window", "build_timeout", "stop_timeout":
+			if _, err := time.ParseDuration(value.(string)); err != nil {
+				v.report(SeverityError, fmt.Sprintf("invalid %s %q: expected a duration such as \"500ms\" or \"2s\"", key, value), at(key)...)
+			}
+		case "log":
+			if _, err := regexp.Compile(value.(string)); err != nil {
+				v.report(SeverityError, fmt.Sprintf("invalid log pattern %q: %v", value, err), at(key)...)
+			}
+		case "kill_delay":
+			if _, err := time.ParseDuration(value.(string)); err != nil {
+				v.report(SeverityError, fmt.Sprintf("invalid kill_delay %q: expected a duration such as \"500ms\" or \"2s\"", value), at(key)...)
+			}
+		case "exclude_regex":
+			for _, item := range value.([]interface{}) {
+				if _, err := regexp.Compile(item.(string)); err != nil {
+					v.report(SeverityError, fmt.Sprintf("invalid exclude_regex pattern %q: %v", item, err), at(key)...)
+				}
+			}
+		case "restart":
+			if value != RestartNo && value != RestartOnFailure && value != RestartAlways {
+				v.report(SeverityError, fmt.Sprintf("restart must be %q, %q or %q, got %q", RestartNo, RestartOnFailure, RestartAlways, value), at(key)...)
+			}
+		case "watch_mode":
+			if value != WatchModeNotify && value != WatchModePoll {
+				v.report(SeverityError, fmt.Sprintf("watch_mode must be %q or %q, got %q", WatchModeNotify, WatchModePoll, value), at(key)...)
+			}
+		case "action":
+			switch value {
+			case ActionRebuild, ActionRestart, ActionSignal, ActionRun, ActionIgnore:
+			default:
+				v.report(SeverityError, fmt.Sprintf("action must be %q, %q, %q, %q or %q, got %q", ActionRebuild, ActionRestart, ActionSignal, ActionRun, ActionIgnore, value), at(key)...)
+			}
+		case "signal":
+			if _, err := ParseSignal(value.(string)); err != nil {
+				v.report(SeverityError, err.Error(), at(key)...)
+			}
+		case "paths":
+			for _, pattern := range stringOrList(value) {
+				if _, err := glob.Compile(pattern); err != nil {
+					v.report(SeverityError, fmt.Sprintf("invalid paths pattern %q: %v", pattern, err), at(key)...)
+				}
+			}
+		case "list_merge":
+			if value != listMergeReplace && value != listMergeAppend {
+				v.report(SeverityError, fmt.Sprintf("list_merge must be %q or %q, got %q", listMergeReplace, listMergeAppend, value), at(key)...)
+			}
+		}
+	}
+}
+
+// checkApp validates the effective settings of an app once the shared
+// defaults have been applied and variables expanded.
+func (v *validator) checkApp(app *App) {
+	if app.RunCmd.IsZero() && app.Bin == "" {
+		if app.BuildCmd.IsZero() && app.Cmd.IsZero() {
+			v.reportKey(SeverityError, fmt.Sprintf("app '%s' has no run_cmd, bin or build_cmd", app.Name), app.Name)
+		} else {
+			v.reportKey(SeverityWarning, fmt.Sprintf("app '%s' has no run_cmd or bin; it will only be built", app.Name), app.Name)
+		}
+	}
+
+	if info, err := os.Stat(app.WorkingDir); err != nil {
+		v.reportKey(SeverityError, fmt.Sprintf("working_dir %q does not exist", app.WorkingDir), app.Name, "working_dir")
+	} else if !info.IsDir() {
+		v.reportKey(SeverityError, fmt.Sprintf("working_dir %q is not a directory", app.WorkingDir), app.Name, "working_dir")
+	}
+
+	for _, watchDir := range app.WatchDir {
+		if info, err := os.Stat(watchDir); err != nil {
+			v.reportKey(SeverityError, fmt.Sprintf("watch_dir %q does not exist", watchDir), app.Name, "watch_dir")
+		} else if !info.IsDir() {
+			v.reportKey(SeverityError, fmt.Sprintf("watch_dir %q is not a directory", watchDir), app.Name, "watch_dir")
+		}
+	}
+
+	for _, pattern := range app.IncludeFile {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			v.reportKey(SeverityError, fmt.Sprintf("invalid include_file pattern %q: %v", pattern, err), app.Name, "include_file")
+		}
+	}
+
+	for _, path := range app.EnvFile {
+		if _, err := LoadEnvFile(path, os.LookupEnv); err != nil {
+			v.reportKey(SeverityError, fmt.Sprintf("env_file %q: %v", path, err), app.Name, "env_file")
+		}
+	}
+
+	if len(app.Shell) == 0 {
+		v.reportKey(SeverityError, "shell must not be empty", app.Name, "shell")
+	}
+
+	commands := map[string][]Command{
+		"run_cmd":   {app.RunCmd},
+		"build_cmd": {app.BuildCmd},
+		"cmd":       {app.Cmd},
+		"pre_cmd":   app.PreCmd,
+		"post_cmd":  app.PostCmd,
+	}
+	for _, key := range []string{"run_cmd", "build_cmd", "cmd", "pre_cmd", "post_cmd"} {
+		for _, cmd := range commands[key] {
+			if _, err := SplitWords(cmd.Line); err != nil {
+				v.reportKey(SeverityError, fmt.Sprintf("%s: %v", key, err), app.Name, key)
+			}
+		}
+	}
+
+	for i, rule := range app.OnChange {
+		key := []string{app.Name, "on_change", strconv.Itoa(i)}
+		if len(rule.Paths) == 0 {
+			v.reportKey(SeverityError, "on_change rule has no paths", key...)
+		}
+		if rule.Action == ActionSignal && rule.Signal == "" {
+			v.reportKey(SeverityError, fmt.Sprintf("on_change rule with action %q has no signal", ActionSignal), key...)
+		}
+		if rule.Action == ActionRun && rule.Run.IsZero() {
+			v.reportKey(SeverityError, fmt.Sprintf("on_change rule with action %q has no run command", ActionRun), key...)
+		}
+		if _, err := SplitWords(rule.Run.Line); err != nil {
+			v.reportKey(SeverityError, fmt.Sprintf("run: %v", err), append(key, "run")...)
+		}
+	}
+
+	if app.Ready != nil {
+		if n := app.Ready.checks(); n != 1 {
+			v.reportKey(SeverityError, fmt.Sprintf("ready must set exactly one of http, tcp, log or file, got %d", n), app.Name, "ready")
+		}
+	}
+
+	if app.Liveness != nil {
+		if n := app.Liveness.checks(); n != 1 {
+			v.reportKey(SeverityError, fmt.Sprintf("liveness must set exactly one of http, tcp or exec, got %d", n), app.Name, "liveness")
+		}
+		if _, err := SplitWords(app.Liveness.Exec.Line); err != nil {
+			v.reportKey(SeverityError, fmt.Sprintf("exec: %v", err), app.Name, "liveness", "exec")
+		}
+	}
+}
+
+// report records a diagnostic positioned at the given key of the document
+// being checked.
+func (v *validator) report(severity Severity, message string, key ...string) {
+	v.reportAt(v.doc.source(key...), severity, message)
+}
+
+// reportKey records a diagnostic about a merged setting, positioned at the
+// file and key it was last defined in.
+func (v *validator) reportKey(severity Severity, message string, key ...string) {
+	src, ok := sourceOf(v.sources, key...)
+	if !ok {
+		src = v.doc.source()
+	}
+	v.reportAt(src, severity, message)
+}
+
+func (v *validator) reportAt(src source, severity Severity, message string) {
+	v.diagnostics = append(v.diagnostics, Diagnostic{
+		File:     src.file,
+		Line:     src.pos.line,
+		Column:   src.pos.col,
+		Severity: severity,
+		Message:  message,
+	})
+}
+
+func (v *validator) typeName(value interface{}, key ...string) string {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return describeValue(value)
+	}
+	if typ := v.doc.md.Type(key...); typ != "" {
+		return strings.ToLower(typ)
+	}
+	return describeValue(value)
+}
+
+func describeValue(value interface{}) string {
+	switch val := value.(type) {
+	case string:
+		return "string"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		for _, item := range val {
+			if _, ok := item.(string); !ok {
+				return fmt.Sprintf("array containing %s", describeValue(item))
+			}
+		}
+		return "array"
+	case map[string]interface{}:
+		for _, item := range val {
+			if _, ok := item.(string); !ok {
+				return fmt.Sprintf("table containing %s", describeValue(item))
+			}
+		}
+		return "table"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func hasKind(value interface{}, kind fieldKind) bool {
+	switch kind {
+	case kindString:
+		_, ok := value.(string)
+		return ok
+	case kindInt:
+		_, ok := value.(int64)
+		return ok
+	case kindBool:
+		_, ok := value.(bool)
+		return ok
+	case kindStringList:
+		list, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range list {
+			if _, ok := item.(string); !ok {
+				return false
+			}
+		}
+		return true
+	case kindStringOrList:
+		if _, ok := value.(string); ok {
+			return true
+		}
+		return hasKind(value, kindStringList)
+	case kindCommandList:
+		list, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range list {
+			if !hasKind(item, kindStringOrList) {
+				return false
+			}
+		}
+		return true
+	case kindTable:
+		_, ok := value.(map[string]interface{})
+		return ok
+	case kindTableList:
+		switch v := value.(type) {
+		case []map[string]interface{}:
+			return true
+		case []interface{}:
+			for _, item := range v {
+				if _, ok := item.(map[string]interface{}); !ok {
+					return false
+				}
+			}
+			return true
+		}
+		return false
+	case kindStringMap:
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range table {
+			if _, ok := item.(string); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func sortedKeys(table map[string]interface{}) []string {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// closestField suggests a known key for a misspelled one.
+func closestField(key string, fields map[string]fieldKind) string {
+	best, bestDist := "", 3
+	for field := range fields {
+		if d := editDistance(key, field); d < bestDist || (d == bestDist && best != "" && field < best) {
+			best, bestDist = field, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+type position struct {
+	line int
+	col  int
+}
+
+// keyPositions scans TOML source and records where each table header and key
+// is defined, keyed by its dotted path. It is deliberately forgiving: it only
+// has to locate keys in a document the TOML parser has already accepted.
+func keyPositions(source string) map[string]position {
+	positions := make(map[string]position)
+
+	var (
+		table       []string
+		depth       int
+		inMultiline bool
+		// arrays counts the tables of each array of tables seen so far
+		arrays = make(map[string]int)
+	)
+
+	for i, line := range strings.Split(source, "\n") {
+		lineNo := i + 1
+
+		if inMultiline {
+			if strings.Count(line, `"""`)%2 == 1 || strings.Count(line, `'''`)%2 == 1 {
+				inMultiline = false
+			}
+			continue
+		}
+		if depth > 0 {
+			depth += bracketDepth(line)
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+
+		if strings.HasPrefix(trimmed, "[") {
+			isArray := strings.HasPrefix(trimmed, "[[")
+			header := strings.TrimPrefix(trimmed, "[[")
+			header = strings.TrimPrefix(header, "[")
+			if end := strings.Index(header, "]"); end >= 0 {
+				header = header[:end]
+			}
+			table = splitKey(header)
+			path := strings.Join(table, ".")
+			if _, seen := positions[path]; !seen {
+				positions[path] = position{line: lineNo, col: indent + 1}
+			}
+			if isArray {
+				// each table of an array is also found by its index
+				table = append(table, strconv.Itoa(arrays[path]))
+				arrays[path]++
+				positions[strings.Join(table, ".")] = position{line: lineNo, col: indent + 1}
+			}
+			continue
+		}
+
+		eq := indexOutsideQuotes(trimmed, '=')
+		if eq < 0 {
+			continue
+		}
+
+		keyParts := append(append([]string{}, table...), splitKey(trimmed[:eq])...)
+		for n := len(table) + 1; n <= len(keyParts); n++ {
+			path := strings.Join(keyParts[:n], ".")
+			if _, seen := positions[path]; !seen {
+				positions[path] = position{line: lineNo, col: indent + 1}
+			}
+		}
+
+		value := trimmed[eq+1:]
+		if strings.Count(value, `"""`)%2 == 1 || strings.Count(value, `'''`)%2 == 1 {
+			inMultiline = true
+			continue
+		}
+		depth = bracketDepth(value)
+		if depth < 0 {
+			depth = 0
+		}
+	}
+
+	return positions
+}
+
+// splitKey splits a dotted TOML key into its parts, honouring quoted parts.
+func splitKey(key string) []string {
+	var (
+		parts   []string
+		current strings.Builder
+		quote   rune
+	)
+
+	for _, r := range key {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '.':
+			parts = append(parts, strings.TrimSpace(current.String()))
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	return append(parts, strings.TrimSpace(current.String()))
+}
+
+func indexOutsideQuotes(s string, target byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return -1
+		case c == target:
+			return i
+		}
+	}
+	return -1
+}
+
+// bracketDepth returns how many arrays or inline tables are left open by line.
+func bracketDepth(line string) int {
+	var (
+		depth int
+		quote byte
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return depth
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth
+}
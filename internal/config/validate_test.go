@@ -0,0 +1,187 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// validateString validates a configuration file with the given contents, and
+// returns its diagnostics as "line:column: severity: message".
+func validateString(t *testing.T, contents string) []string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "wisp.toml")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	diagnostics, err := Validate([]string{path}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range diagnostics {
+		if d.File != path {
+			t.Errorf("diagnostic for %s, want %s", d.File, path)
+		}
+		got = append(got, fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Column, d.Severity, d.Message))
+	}
+	return got
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{
+			name: "valid",
+			contents: `
+[api]
+run_cmd = "./api"
+`,
+		},
+		{
+			name: "unknown key",
+			contents: `
+[api]
+run_cmd = "./api"
+bulid_cmd = "go build"
+`,
+			want: []string{"4:1: error: unknown key 'bulid_cmd' in [api] (did you mean 'build_cmd'?)"},
+		},
+		{
+			name: "unknown key without suggestion",
+			contents: `
+[api]
+run_cmd = "./api"
+frobnicate = true
+`,
+			want: []string{"4:1: error: unknown key 'frobnicate' in [api]"},
+		},
+		{
+			name: "wrong type",
+			contents: `
+[api]
+run_cmd = "./api"
+delay = "1s"
+`,
+			want: []string{"4:1: error: 'delay' must be an integer, got string"},
+		},
+		{
+			name: "invalid duration",
+			contents: `
+[api]
+run_cmd = "./api"
+build_timeout = "5 minutes"
+`,
+			want: []string{`4:1: error: invalid build_timeout "5 minutes": expected a duration such as "500ms" or "2s"`},
+		},
+		{
+			name: "invalid enum",
+			contents: `
+[api]
+run_cmd = "./api"
+restart = "sometimes"
+`,
+			want: []string{`4:1: error: restart must be "no", "on-failure" or "always", got "sometimes"`},
+		},
+		{
+			name: "nested table",
+			contents: `
+[api]
+run_cmd = "./api"
+
+[api.ready]
+http = "http://localhost:8080"
+timout = "10s"
+`,
+			want: []string{"7:1: error: unknown key 'timout' in [api.ready] (did you mean 'timeout'?)"},
+		},
+		{
+			name: "no run command",
+			contents: `
+[api]
+delay = 100
+`,
+			want: []string{"2:1: error: app 'api' has no run_cmd, bin or build_cmd"},
+		},
+		{
+			name: "only built",
+			contents: `
+[api]
+build_cmd = "go build ./..."
+`,
+			want: []string{"2:1: warning: app 'api' has no run_cmd or bin; it will only be built"},
+		},
+		{
+			name: "unterminated quote",
+			contents: `
+[api]
+run_cmd = "./api 'oops"
+`,
+			want: []string{`3:1: error: run_cmd: unterminated single quote in "./api 'oops"`},
+		},
+		{
+			name: "defaults merged",
+			contents: `
+[defaults]
+restart = "always"
+
+[api]
+delay = 100
+`,
+			want: []string{"5:1: error: app 'api' has no run_cmd, bin or build_cmd"},
+		},
+		{
+			name: "undefined variable",
+			contents: `
+[api]
+run_cmd = "./api --port ${env.WISP_TEST_UNSET}"
+`,
+			want: []string{"3:1: error: undefined variable ${env.WISP_TEST_UNSET}"},
+		},
+		{
+			name: "dependency cycle",
+			contents: `
+[api]
+run_cmd = "./api"
+depends_on = ["worker"]
+
+[worker]
+run_cmd = "./worker"
+depends_on = ["api"]
+`,
+			want: []string{"4:1: error: dependency cycle api -> worker -> api"},
+		},
+		{
+			name: "several problems in file order",
+			contents: `
+[api]
+run_cmd = "./api"
+delay = "1s"
+
+[worker]
+run_cmd = "./worker"
+watch_mode = "inotify"
+`,
+			want: []string{
+				"4:1: error: 'delay' must be an integer, got string",
+				`8:1: error: watch_mode must be "notify" or "poll", got "inotify"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateString(t, tt.contents)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("diagnostics:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -21,11 +21,11 @@ func GenerateConfig(project *analyzer.ProjectStructure) string {
 
 			config.WriteString(fmt.Sprintf("# %s application\n", strings.Title(appName)))
 			config.WriteString(fmt.Sprintf("[%s]\n", appName))
-			config.WriteString(fmt.Sprintf("  cmd = \"go build -o ./tmp/%s ./%s\"\n", appName, cmdDir))
-			config.WriteString(fmt.Sprintf("  bin = \"./tmp/%s\"\n", appName))
+			config.WriteString(fmt.Sprintf("  cmd = \"go build -o ${session_dir}/%s ./%s\"\n", appName, cmdDir))
+			config.WriteString(fmt.Sprintf("  bin = \"${session_dir}/%s\"\n", appName))
 			config.WriteString("  args = []\n")
 			config.WriteString(fmt.Sprintf("  watch_dir = \"./%s\"\n", cmdDir))
-			config.WriteString("  tmp_dir = \"./tmp\"\n")
+			config.WriteString("  tmp_dir = \"${session_dir}\"\n")
 			config.WriteString("  delay = 1000\n")
 			config.WriteString("  exclude_dir = [\"vendor\", \"tmp\", \"testdata\", \".git\"]\n")
 			config.WriteString("  exclude_file = [\"*_test.go\", \"*.log\"]\n")
@@ -50,11 +50,11 @@ func GenerateConfig(project *analyzer.ProjectStructure) string {
 
 		config.WriteString(fmt.Sprintf("# %s application\n", strings.Title(appName)))
 		config.WriteString(fmt.Sprintf("[%s]\n", appName))
-		config.WriteString(fmt.Sprintf("  cmd = \"go build -o ./tmp/%s .\"\n", appName))
-		config.WriteString(fmt.Sprintf("  bin = \"./tmp/%s\"\n", appName))
+		config.WriteString(fmt.Sprintf("  cmd = \"go build -o ${session_dir}/%s .\"\n", appName))
+		config.WriteString(fmt.Sprintf("  bin = \"${session_dir}/%s\"\n", appName))
 		config.WriteString("  args = []\n")
 		config.WriteString("  watch_dir = \".\"\n")
-		config.WriteString("  tmp_dir = \"./tmp\"\n")
+		config.WriteString("  tmp_dir = \"${session_dir}\"\n")
 		config.WriteString("  delay = 1000\n")
 		config.WriteString("  exclude_dir = [\"vendor\", \"tmp\", \"testdata\", \".git\"]\n")
 		config.WriteString("  exclude_file = [\"*_test.go\", \"*.log\"]\n")
@@ -0,0 +1,77 @@
+// Package glob matches slash-separated paths against patterns in the style
+// of .gitignore files.
+package glob
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Pattern is a compiled glob. A pattern containing a slash, other than a
+// trailing one, is anchored: it matches paths from the directory it is
+// relative to. Otherwise it matches a name at any depth. * and ? do not
+// match a slash, and ** matches any number of directories.
+type Pattern struct {
+	re *regexp.Regexp
+}
+
+// Compile parses a glob. Backslashes escape the character that follows.
+func Compile(pattern string) (*Pattern, error) {
+	pattern = strings.TrimRight(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	expr := toRegexp(pattern)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, err
+	}
+	return &Pattern{re: re}, nil
+}
+
+// Match reports whether a slash-separated path, relative to the directory
+// the pattern applies to, matches.
+func (p *Pattern) Match(path string) bool {
+	return p.re.MatchString(path)
+}
+
+func toRegexp(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			// leading or inner **/ matches zero or more directories
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**") && i+2 == len(glob):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
@@ -0,0 +1,312 @@
+// Package godeps finds the local packages a Go program is built from, so
+// that only changes to them need to trigger a rebuild.
+package godeps
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Graph is the set of local packages a main package depends on, along with
+// the files that make up each of them.
+type Graph struct {
+	// Dir is where go list runs, Package is the main package and Tags are
+	// the build tags it is built with.
+	Dir     string
+	Package string
+	Tags    string
+	Env     []string
+
+	mu sync.Mutex
+	// dirs holds the directories of the local packages.
+	dirs map[string]bool
+	// imports holds the imports of each Go file of the local packages.
+	imports map[string][]string
+	// ignored holds the Go files excluded by build constraints.
+	ignored map[string]bool
+	// files holds the other files the build reads: embedded files, non-Go
+	// sources and the go.mod, go.sum and go.work files.
+	files map[string]bool
+	stale bool
+}
+
+// listedPackage holds the fields of go list's output that are used.
+type listedPackage struct {
+	Dir            string
+	ImportPath     string
+	Standard       bool
+	Module         *listedModule
+	GoFiles        []string
+	CgoFiles       []string
+	IgnoredGoFiles []string
+	EmbedFiles     []string
+	CFiles         []string
+	CXXFiles       []string
+	HFiles         []string
+	SFiles         []string
+	SysoFiles      []string
+}
+
+type listedModule struct {
+	Path    string
+	Main    bool
+	Dir     string
+	GoMod   string
+	Replace *listedModule
+	Version string
+}
+
+// Load lists the dependencies of pkg, run from dir.
+func Load(dir, pkg, tags string, env []string) (*Graph, error) {
+	g := &Graph{Dir: dir, Package: pkg, Tags: tags, Env: env}
+	if err := g.Reload(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Reload lists the dependencies again, after go.mod, go.work or imports
+// have changed.
+func (g *Graph) Reload() error {
+	args := []string{"list", "-e", "-deps", "-json=Dir,ImportPath,Standard,Module,GoFiles,CgoFiles,IgnoredGoFiles,EmbedFiles,CFiles,CXXFiles,HFiles,SFiles,SysoFiles"}
+	if g.Tags != "" {
+		args = append(args, "-tags", g.Tags)
+	}
+	args = append(args, g.Package)
+
+	output, err := g.goCommand(args...)
+	if err != nil {
+		return err
+	}
+
+	dirs := make(map[string]bool)
+	imports := make(map[string][]string)
+	ignored := make(map[string]bool)
+	files := make(map[string]bool)
+
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for {
+		var p listedPackage
+		if err := decoder.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("go list: %w", err)
+		}
+		if !isLocal(p) {
+			continue
+		}
+
+		dirs[p.Dir] = true
+		for _, name := range append(p.GoFiles, p.CgoFiles...) {
+			path := filepath.Join(p.Dir, name)
+			imports[path], _ = fileImports(path)
+		}
+		for _, name := range p.IgnoredGoFiles {
+			ignored[filepath.Join(p.Dir, name)] = true
+		}
+		for _, list := range [][]string{p.EmbedFiles, p.CFiles, p.CXXFiles, p.HFiles, p.SFiles, p.SysoFiles} {
+			for _, name := range list {
+				files[filepath.Join(p.Dir, name)] = true
+			}
+		}
+
+		module := p.Module
+		if module.Replace != nil {
+			module = module.Replace
+		}
+		if module.GoMod != "" {
+			files[module.GoMod] = true
+			files[filepath.Join(filepath.Dir(module.GoMod), "go.sum")] = true
+		}
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no local packages found for %s", g.Package)
+	}
+
+	if output, err := g.goCommand("env", "GOWORK"); err == nil {
+		if work := strings.TrimSpace(string(output)); work != "" && work != "off" {
+			files[work] = true
+			files[work+".sum"] = true
+		}
+	}
+
+	g.mu.Lock()
+	g.dirs, g.imports, g.ignored, g.files = dirs, imports, ignored, files
+	g.stale = false
+	g.mu.Unlock()
+
+	return nil
+}
+
+func (g *Graph) goCommand(args ...string) ([]byte, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = g.Dir
+	cmd.Env = g.Env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("go %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("go %s: %w", args[0], err)
+	}
+	return output, nil
+}
+
+// isLocal reports whether a package is part of the main modules, which
+// include the members of a go.work workspace, or of a module replaced by a
+// local directory.
+func isLocal(p listedPackage) bool {
+	if p.Standard || p.Module == nil || p.Dir == "" {
+		return false
+	}
+	if p.Module.Main {
+		return true
+	}
+	return p.Module.Replace != nil && p.Module.Replace.Version == ""
+}
+
+// Dirs returns the directories that need watching, in order.
+func (g *Graph) Dirs() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	set := make(map[string]bool, len(g.dirs))
+	for dir := range g.dirs {
+		set[dir] = true
+	}
+	for file := range g.files {
+		set[filepath.Dir(file)] = true
+	}
+
+	dirs := make([]string, 0, len(set))
+	for dir := range set {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// Packages returns how many local packages the graph holds.
+func (g *Graph) Packages() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.dirs)
+}
+
+// Contains reports whether a change to path affects the build. Changes that
+// may alter the graph itself, such as to go.mod or to a file's imports, also
+// mark the graph as stale.
+func (g *Graph) Contains(path string) bool {
+	path = filepath.Clean(path)
+	base := filepath.Base(path)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.files[path] {
+		if base == "go.mod" || base == "go.work" {
+			g.stale = true
+		}
+		return true
+	}
+
+	if filepath.Ext(path) != ".go" || strings.HasSuffix(base, "_test.go") || !g.dirs[filepath.Dir(path)] {
+		return false
+	}
+	if g.ignored[path] {
+		return false
+	}
+
+	known, ok := g.imports[path]
+	current, err := fileImports(path)
+	if !ok || err != nil || !slices.Equal(known, current) {
+		// a new, removed or unparsable file, or changed imports
+		g.stale = true
+	}
+	return true
+}
+
+// Stale reports whether the graph needs to be reloaded.
+func (g *Graph) Stale() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stale
+}
+
+// fileImports returns the sorted import paths of a Go file.
+func fileImports(path string) ([]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	imports := make([]string, 0, len(file.Imports))
+	for _, spec := range file.Imports {
+		if importPath, err := strconv.Unquote(spec.Path.Value); err == nil {
+			imports = append(imports, importPath)
+		}
+	}
+	sort.Strings(imports)
+	return imports, nil
+}
+
+// MainPackage finds the package built by a go build command, given as words,
+// along with the directory it is built in, from -C, and its build tags. It
+// returns "." if the command names no package.
+func MainPackage(words []string) (dir, pkg, tags string) {
+	build := slices.Index(words, "build")
+	if build < 0 || !slices.Contains(words[:build], "go") {
+		return "", ".", ""
+	}
+	if c := slices.Index(words[:build], "-C"); c >= 0 && c+1 < build {
+		dir = words[c+1]
+	}
+
+	pkg = "."
+	args := words[build+1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "&&" || arg == "||" || arg == ";" || arg == "|" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			pkg = arg
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !hasValue && takesValue(name) && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		if name == "tags" {
+			tags = value
+		}
+	}
+	return dir, pkg, tags
+}
+
+// takesValue reports whether a go build flag is followed by a value.
+func takesValue(flag string) bool {
+	switch flag {
+	case "o", "p", "C", "tags", "ldflags", "gcflags", "asmflags", "gccgoflags",
+		"mod", "modfile", "overlay", "pgo", "pkgdir", "buildmode", "compiler",
+		"installsuffix", "toolexec", "covermode", "coverpkg":
+		return true
+	}
+	return false
+}
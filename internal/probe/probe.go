@@ -0,0 +1,352 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mktcz/wisp/internal/config"
+)
+
+// Probe checks once whether an app is ready, or still responding.
+type Probe interface {
+	Check(ctx context.Context) error
+	String() string
+}
+
+// LineObserver is implemented by probes that look at the app's output.
+type LineObserver interface {
+	Observe(line string)
+}
+
+// Resetter is implemented by probes that keep state between checks. Reset is
+// called whenever the app is started again.
+type Resetter interface {
+	Reset()
+}
+
+// Readiness repeats a probe until it succeeds or the timeout elapses.
+type Readiness struct {
+	Probe    Probe
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// TimeoutError is returned by Wait when the app did not become ready in time.
+type TimeoutError struct {
+	Probe   Probe
+	Timeout time.Duration
+	// Err is the result of the last check.
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s not ready after %v: %v", e.Probe, e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// New builds the readiness check described by check.
+func New(check *config.ReadyCheck) (*Readiness, error) {
+	interval, err := time.ParseDuration(check.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval: %w", err)
+	}
+	timeout, err := time.ParseDuration(check.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout: %w", err)
+	}
+
+	var probe Probe
+	switch {
+	case check.HTTP != "":
+		probe = &HTTP{URL: check.HTTP, Status: check.Status}
+	case check.TCP != "":
+		probe = &TCP{Address: check.TCP}
+	case check.Log != "":
+		pattern, err := regexp.Compile(check.Log)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log pattern: %w", err)
+		}
+		probe = &Log{Pattern: pattern}
+	case check.File != "":
+		probe = &File{Path: check.File}
+	default:
+		return nil, errors.New("no http, tcp, log or file check configured")
+	}
+
+	return &Readiness{Probe: probe, Interval: interval, Timeout: timeout}, nil
+}
+
+// Wait checks the probe every interval until it succeeds, returning a
+// *TimeoutError if it does not within the timeout, or ctx's error if ctx is
+// done first.
+func (r *Readiness) Wait(ctx context.Context) error {
+	deadline := time.NewTimer(r.Timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, r.Interval)
+		err := r.Probe.Check(checkCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return &TimeoutError{Probe: r.Probe, Timeout: r.Timeout, Err: err}
+		case <-ticker.C:
+		}
+	}
+}
+
+// Liveness checks a running app periodically and reports when it has stopped
+// responding.
+type Liveness struct {
+	Probe            Probe
+	Interval         time.Duration
+	Timeout          time.Duration
+	FailureThreshold int
+}
+
+// NewLiveness builds the liveness check described by check. Exec checks run
+// through shell in dir, like the app's other commands.
+func NewLiveness(check *config.LivenessCheck, shell []string, dir string) (*Liveness, error) {
+	interval, err := time.ParseDuration(check.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval: %w", err)
+	}
+	timeout, err := time.ParseDuration(check.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout: %w", err)
+	}
+
+	var probe Probe
+	switch {
+	case check.HTTP != "":
+		probe = &HTTP{URL: check.HTTP, Status: check.Status}
+	case check.TCP != "":
+		probe = &TCP{Address: check.TCP}
+	case !check.Exec.IsZero():
+		probe = &Exec{Command: check.Exec, Shell: shell, Dir: dir}
+	default:
+		return nil, errors.New("no http, tcp or exec check configured")
+	}
+
+	return &Liveness{
+		Probe:            probe,
+		Interval:         interval,
+		Timeout:          timeout,
+		FailureThreshold: max(check.FailureThreshold, 1),
+	}, nil
+}
+
+// Watch checks the probe every interval until ctx is done. Once
+// FailureThreshold checks in a row have failed it stops and returns the last
+// error; it returns nil if ctx is done first.
+func (l *Liveness) Watch(ctx context.Context) error {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, l.Timeout)
+		err := l.Probe.Check(checkCtx)
+		cancel()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		failures++
+		if failures >= l.FailureThreshold {
+			return err
+		}
+	}
+}
+
+// HTTP succeeds once a GET request to URL answers with Status.
+type HTTP struct {
+	URL    string
+	Status int
+}
+
+func (p *HTTP) Check(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != p.Status {
+		return fmt.Errorf("got status %d, want %d", resp.StatusCode, p.Status)
+	}
+	return nil
+}
+
+func (p *HTTP) String() string {
+	return "GET " + p.URL
+}
+
+// TCP succeeds once Address accepts connections.
+type TCP struct {
+	Address string
+}
+
+func (p *TCP) Check(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.Address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p *TCP) String() string {
+	return "tcp " + p.Address
+}
+
+// Log is ready once a line of the app's output matches Pattern.
+type Log struct {
+	Pattern *regexp.Regexp
+
+	mu      sync.Mutex
+	matched bool
+}
+
+func (p *Log) Observe(line string) {
+	if !p.Pattern.MatchString(line) {
+		return
+	}
+	p.mu.Lock()
+	p.matched = true
+	p.mu.Unlock()
+}
+
+func (p *Log) Reset() {
+	p.mu.Lock()
+	p.matched = false
+	p.mu.Unlock()
+}
+
+func (p *Log) Check(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.matched {
+		return errors.New("no matching output yet")
+	}
+	return nil
+}
+
+func (p *Log) String() string {
+	return fmt.Sprintf("log /%s/", p.Pattern)
+}
+
+// Exec succeeds when Command, run through Shell in Dir, exits with status 0.
+// Environ, when set, provides the environment the command runs with.
+type Exec struct {
+	Command config.Command
+	Shell   []string
+	Dir     string
+	Environ func() ([]string, error)
+}
+
+func (p *Exec) Check(ctx context.Context) error {
+	parts := p.Command.Args(p.Shell)
+	if len(parts) == 0 {
+		return errors.New("empty command")
+	}
+
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	cmd.Dir = p.Dir
+	if p.Environ != nil {
+		env, err := p.Environ()
+		if err != nil {
+			return err
+		}
+		cmd.Env = env
+	}
+	// kill anything the command started when the check times out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if out := strings.TrimSpace(string(output)); out != "" {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+		return err
+	}
+	return nil
+}
+
+func (p *Exec) String() string {
+	return "exec " + p.Command.String()
+}
+
+// File is ready once Path exists. A file left over from before the app was
+// last started does not count.
+type File struct {
+	Path string
+
+	mu      sync.Mutex
+	started time.Time
+}
+
+func (p *File) Reset() {
+	p.mu.Lock()
+	// file systems may store modification times at second granularity
+	p.started = time.Now().Truncate(time.Second)
+	p.mu.Unlock()
+}
+
+func (p *File) Check(ctx context.Context) error {
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if info.ModTime().Before(p.started) {
+		return fmt.Errorf("%s is left over from a previous run", p.Path)
+	}
+	return nil
+}
+
+func (p *File) String() string {
+	return "file " + p.Path
+}
@@ -0,0 +1,79 @@
+package process
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"io"
+	"os"
+)
+
+// Mach-O load commands holding values derived from the Go build ID.
+const (
+	loadCmdUUID          macho.LoadCmd = 0x1b
+	loadCmdCodeSignature macho.LoadCmd = 0x1d
+)
+
+// goBuildIDPrefix comes before the build ID the Go linker writes at the
+// start of the text of non-ELF binaries.
+var goBuildIDPrefix = []byte("\xff Go build ID: \"")
+
+// hashBinary writes the contents of the binary at path to w, with its build
+// IDs left out. The Go linker derives them from the hashes of the sources,
+// so they change with every edit, even one that leaves the code as it was.
+func hashBinary(w io.Writer, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, r := range buildIDs(data) {
+		clear(data[r[0]:r[1]])
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// buildIDs returns the ranges of data holding build IDs: Go's own, and the
+// ELF build ID or the Mach-O UUID and code signature derived from it.
+func buildIDs(data []byte) [][2]int {
+	var ranges [][2]int
+	add := func(offset, size uint64) {
+		if offset+size <= uint64(len(data)) {
+			ranges = append(ranges, [2]int{int(offset), int(offset + size)})
+		}
+	}
+
+	if f, err := elf.NewFile(bytes.NewReader(data)); err == nil {
+		for _, s := range f.Sections {
+			if s.Type == elf.SHT_NOTE && (s.Name == ".note.go.buildid" || s.Name == ".note.gnu.build-id") {
+				add(s.Offset, s.Size)
+			}
+		}
+	} else if f, err := macho.NewFile(bytes.NewReader(data)); err == nil {
+		offset := uint64(28)
+		if f.Magic == macho.Magic64 {
+			offset = 32
+		}
+		for _, load := range f.Loads {
+			raw := load.Raw()
+			if len(raw) < 16 {
+				break
+			}
+			switch macho.LoadCmd(f.ByteOrder.Uint32(raw)) {
+			case loadCmdUUID:
+				add(offset+8, 16)
+			case loadCmdCodeSignature:
+				add(uint64(f.ByteOrder.Uint32(raw[8:])), uint64(f.ByteOrder.Uint32(raw[12:])))
+			}
+			offset += uint64(len(raw))
+		}
+	}
+
+	if i := bytes.Index(data, goBuildIDPrefix); i >= 0 {
+		start := i + len(goBuildIDPrefix)
+		if end := bytes.IndexByte(data[start:], '"'); end >= 0 {
+			add(uint64(start), uint64(end))
+		}
+	}
+	return ranges
+}
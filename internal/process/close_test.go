@@ -0,0 +1,70 @@
+package process
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mktcz/wisp/internal/config"
+)
+
+func TestCloseCancelsRestart(t *testing.T) {
+	dir := t.TempDir()
+	// the build writes to the fifo once it runs, which blocks until the
+	// test reads it
+	fifo := filepath.Join(dir, "building")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &config.App{
+		Name:        "app",
+		WorkingDir:  dir,
+		BuildCmd:    config.Command{Argv: []string{"sh", "-c", "echo > building; exec sleep 30"}},
+		RunCmd:      config.Command{Argv: []string{"sleep", "30"}},
+		StopTimeout: "1s",
+	}
+	m := NewManager(app)
+	t.Cleanup(func() { m.Stop() })
+
+	restarted := make(chan error, 1)
+	go func() { restarted <- m.Restart() }()
+	if _, err := os.ReadFile(fifo); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Close()
+	select {
+	case err := <-restarted:
+		if !errors.Is(err, ErrSuperseded) {
+			t.Errorf("restart in progress = %v, want %v", err, ErrSuperseded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the restart in progress did not return")
+	}
+	if m.IsRunning() {
+		t.Error("the restart in progress started the process")
+	}
+
+	for name, restart := range map[string]func() error{
+		"Restart":             m.Restart,
+		"RestartWithoutBuild": m.RestartWithoutBuild,
+	} {
+		done := make(chan error, 1)
+		go func() { done <- restart() }()
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrSuperseded) {
+				t.Errorf("%s after Close = %v, want %v", name, err, ErrSuperseded)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s after Close did not return", name)
+		}
+	}
+	if m.IsRunning() {
+		t.Error("a restart after Close started the process")
+	}
+}
@@ -0,0 +1,109 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// dumpTimeout is how long a process may take to print its goroutine dump and
+// exit after SIGQUIT.
+const dumpTimeout = 5 * time.Second
+
+// watchLiveness runs the liveness check against cmd until it exits, and
+// restarts the app once the check keeps failing.
+func (m *Manager) watchLiveness(cmd *exec.Cmd, exited <-chan struct{}) {
+	if m.liveness == nil || cmd == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-exited:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	go func() {
+		defer cancel()
+		if err := m.liveness.Watch(ctx); err != nil {
+			m.livenessFailed(cmd, exited, err)
+		}
+	}()
+}
+
+// livenessFailed captures diagnostics from the unresponsive process and
+// restarts the app.
+func (m *Manager) livenessFailed(cmd *exec.Cmd, exited <-chan struct{}, err error) {
+	m.mu.Lock()
+	if m.cmd != cmd {
+		// restarted or stopped meanwhile
+		m.mu.Unlock()
+		return
+	}
+	// the restart below replaces the process, so its exit is expected
+	m.halted = true
+	m.mu.Unlock()
+
+	log.Printf("[%s] Liveness check (%s) failed %d times in a row: %v", m.app.Name, m.liveness.Probe, m.liveness.FailureThreshold, err)
+
+	if m.app.Liveness != nil && m.app.Liveness.GoroutineDump {
+		if path, err := m.captureGoroutineDump(cmd, exited); err != nil {
+			log.Printf("[%s] Failed to capture goroutine dump: %v", m.app.Name, err)
+		} else {
+			log.Printf("[%s] Saved goroutine dump to %s", m.app.Name, path)
+		}
+	}
+
+	if err := m.Restart(); err != nil {
+		log.Printf("[%s] Restart failed: %v", m.app.Name, err)
+	}
+}
+
+// captureGoroutineDump sends SIGQUIT, which makes Go programs print the stack
+// of every goroutine and exit, and saves what the process prints until it
+// exits into the diagnostics directory.
+func (m *Manager) captureGoroutineDump(cmd *exec.Cmd, exited <-chan struct{}) (string, error) {
+	dir := m.diagnosticsDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-goroutines-%s.txt", m.app.Name, time.Now().Format("20060102-150405")))
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	m.outputMu.Lock()
+	m.dump = file
+	m.outputMu.Unlock()
+	defer func() {
+		m.outputMu.Lock()
+		m.dump = nil
+		m.outputMu.Unlock()
+	}()
+
+	if err := cmd.Process.Signal(syscall.SIGQUIT); err != nil {
+		return "", fmt.Errorf("failed to send SIGQUIT: %w", err)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(dumpTimeout):
+		log.Printf("[%s] Process still running %v after SIGQUIT", m.app.Name, dumpTimeout)
+	}
+
+	return path, nil
+}
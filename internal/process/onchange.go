@@ -0,0 +1,65 @@
+package process
+
+import (
+	"errors"
+	"log"
+	"syscall"
+
+	"github.com/mktcz/wisp/internal/config"
+)
+
+// ErrNotRunning is returned by Signal when the app has no running process.
+var ErrNotRunning = errors.New("not running")
+
+// Signal sends sig to the app's process group, for instance SIGHUP to make
+// it reload its configuration.
+func (m *Manager) Signal(sig syscall.Signal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.running || m.cmd == nil || m.cmd.Process == nil {
+		return ErrNotRunning
+	}
+
+	if !m.app.LogSilent {
+		log.Printf("[%s] Sending %v to PID %d", m.app.Name, sig, m.cmd.Process.Pid)
+	}
+	if pgid, err := syscall.Getpgid(m.cmd.Process.Pid); err == nil {
+		return syscall.Kill(-pgid, sig)
+	}
+	return m.cmd.Process.Signal(sig)
+}
+
+// RunCommand runs a command the way hooks are run, leaving the running
+// process alone. It waits for a restart in progress to finish first, and
+// returns ErrSuperseded if a restart requested meanwhile cancels it.
+func (m *Manager) RunCommand(command config.Command) error {
+	m.restartMu.Lock()
+	defer m.restartMu.Unlock()
+
+	// like a build, the command is killed when it outlives the build timeout
+	// or a restart is requested meanwhile
+	m.mu.Lock()
+	gen := m.buildGen
+	m.mu.Unlock()
+	ctx, done, ok := m.beginBuild(gen)
+	if !ok {
+		return ErrSuperseded
+	}
+	defer done()
+
+	if !m.app.LogSilent {
+		log.Printf("[%s] Running: %s", m.app.Name, command)
+	}
+	if err := m.runWithTimeout(ctx, "on_change", command); err != nil {
+		if ctx.Err() != nil {
+			if !m.app.LogSilent {
+				log.Printf("[%s] Command cancelled", m.app.Name)
+			}
+			return ErrSuperseded
+		}
+		m.commandFailed("Command failed", err)
+		return err
+	}
+	return nil
+}
@@ -2,17 +2,22 @@ package process
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/mktcz/wisp/internal/config"
+	"github.com/mktcz/wisp/internal/probe"
 )
 
 type Manager struct {
@@ -22,65 +27,229 @@ type Manager struct {
 	running      bool
 	stopDelay    time.Duration
 	startDelay   time.Duration
+	stopTimeout  time.Duration
 	buildTimeout time.Duration
 	tmpFiles     []string
+	exited       chan struct{}
+	readiness    *probe.Readiness
+	ready        bool
+	startedAt    time.Time
+	// halted is set when wisp stops the process on purpose, so that its
+	// exit does not trigger the restart policy.
+	halted   bool
+	crashes  crashHistory
+	output   []string
+	outputMu sync.Mutex
+	// buildOutput holds the last lines printed by the last hook or build
+	// command.
+	buildOutput []string
+	// dump receives the output of a process asked for a goroutine dump.
+	dump           *os.File
+	liveness       *probe.Liveness
+	diagnosticsDir string
+	// restartMu serialises restarts requested from different places, such
+	// as file changes and failed liveness checks.
+	restartMu sync.Mutex
+	// buildGen counts restart requests; buildCancel cancels the commands of
+	// the restart in progress, once a newer one has been requested.
+	buildGen    uint64
+	buildCancel context.CancelFunc
+	// closed is set once the app is being stopped for good, after which no
+	// restart begins.
+	closed bool
+	// buildDir is where build_swap builds; binary is the binary it last
+	// built successfully, which is run in place of binaryOf, the word of
+	// the run command naming the configured binary.
+	buildDir string
+	binary   string
+	binaryOf string
+	// runningDigest identifies what the process runs, with skip_unchanged.
+	runningDigest string
+}
+
+// outputGrace bounds the wait for the last output of a process that exited.
+const outputGrace = 100 * time.Millisecond
+
+// killTimeout bounds the wait for a process to be reaped after SIGKILL.
+const killTimeout = 5 * time.Second
+
+// ErrSuperseded is returned by Restart when a newer restart was requested
+// before it finished, cancelling its build.
+var ErrSuperseded = errors.New("superseded by a newer restart")
+
+// NotReadyError reports a process that was started but did not become ready,
+// either because its readiness check timed out or because it exited first.
+type NotReadyError struct {
+	Err error
+}
+
+func (e *NotReadyError) Error() string {
+	return fmt.Sprintf("did not become ready: %v", e.Err)
+}
+
+func (e *NotReadyError) Unwrap() error {
+	return e.Err
 }
 
 func NewManager(app *config.App) *Manager {
+	buildTimeout := 5 * time.Minute
+	if app.BuildTimeout != "" {
+		if d, err := time.ParseDuration(app.BuildTimeout); err == nil {
+			buildTimeout = d
+		}
+	}
+
+	stopTimeout := 5 * time.Second
+	if app.StopTimeout != "" {
+		if d, err := time.ParseDuration(app.StopTimeout); err == nil && d > 0 {
+			stopTimeout = d
+		}
+	}
+
 	return &Manager{
 		app:          app,
-		stopDelay:    500 * time.Millisecond,
 		startDelay:   200 * time.Millisecond,
-		buildTimeout: 30 * time.Second,
+		stopTimeout:  stopTimeout,
+		buildTimeout: buildTimeout,
 	}
 }
 
+// SetDelays sets how long to wait after the process has stopped before it is
+// restarted, unless the app sets kill_delay, and how long it must keep
+// running after it is started to count as started, when there is no
+// readiness check.
 func (m *Manager) SetDelays(stopDelay, startDelay time.Duration) {
 	m.stopDelay = stopDelay
 	m.startDelay = startDelay
 }
 
+// SetReadiness makes every start wait until the readiness check passes.
+func (m *Manager) SetReadiness(readiness *probe.Readiness) {
+	m.readiness = readiness
+}
+
+// SetLiveness checks the process periodically once it is ready, restarting
+// it when the check keeps failing. Diagnostics captured before the restart
+// are saved into diagnosticsDir.
+func (m *Manager) SetLiveness(liveness *probe.Liveness, diagnosticsDir string) {
+	// exec checks see the same environment as the app's other commands
+	if check, ok := liveness.Probe.(*probe.Exec); ok {
+		check.Environ = m.environ
+	}
+	m.liveness = liveness
+	m.diagnosticsDir = diagnosticsDir
+}
+
+// Restart stops the process, runs the hooks and the build, and starts it
+// again. Calling Restart while another restart is building cancels that
+// build, which then returns ErrSuperseded.
 func (m *Manager) Restart() error {
+	gen := m.supersedeBuild()
+
+	m.restartMu.Lock()
+	defer m.restartMu.Unlock()
+
+	ctx, done, ok := m.beginBuild(gen)
+	if !ok {
+		return ErrSuperseded
+	}
+	defer done()
+
 	if !m.app.LogSilent {
 		log.Printf("[%s] Restarting...", m.app.Name)
 	}
 
-	if err := m.Stop(); err != nil {
-		log.Printf("[%s] Warning: failed to stop cleanly: %v", m.app.Name, err)
+	buildCmd := m.buildCmd()
+	binaryPath := m.binaryPath()
+
+	// with build_swap the binary is built to a fresh path while the old
+	// process keeps running, and swapped in once the build has succeeded
+	var swapPath, swapOf string
+	if m.app.BuildSwap && !buildCmd.IsZero() {
+		swapCmd, path, ok := m.swapBuildCmd(buildCmd, binaryPath, gen)
+		runWord, runs := m.runWord(binaryPath)
+		switch {
+		case !ok:
+			log.Printf("[%s] Warning: build_swap needs the binary path (bin or -o) in the build command, stopping before the build instead", m.app.Name)
+		case !runs:
+			log.Printf("[%s] Warning: build_swap needs the run command to run the binary the build writes (%s), stopping before the build instead", m.app.Name, binaryPath)
+		default:
+			buildCmd, swapPath, swapOf = swapCmd, path, runWord
+		}
 	}
+	swapped := false
+	defer func() {
+		if swapPath != "" && !swapped {
+			os.Remove(swapPath)
+		}
+	}()
 
-	if m.app.KillDelay != "" {
-		if duration, err := time.ParseDuration(m.app.KillDelay); err == nil && duration > 0 {
-			if !m.app.LogSilent {
-				log.Printf("[%s] Waiting %v after stop...", m.app.Name, duration)
-			}
-			time.Sleep(duration)
+	// with skip_unchanged the process keeps running through the build too,
+	// and is only replaced if the build changed what it runs. Without
+	// build_swap, the binary is built next to the running one and moved over
+	// it once the process is stopped.
+	skip := m.app.SkipUnchanged && !buildCmd.IsZero() && binaryPath != ""
+	var freshPath string
+	if skip && swapPath == "" {
+		if freshCmd, path, ok := m.freshBuildCmd(buildCmd, binaryPath, gen); ok {
+			buildCmd, freshPath = freshCmd, path
+		} else {
+			skip = false
 		}
 	}
+	defer func() {
+		if freshPath != "" {
+			os.Remove(freshPath)
+		}
+	}()
+	stopped := false
+	if swapPath == "" && !skip {
+		m.stopForRestart()
+		stopped = true
+	}
 
 	for _, preCmd := range m.app.PreCmd {
 		if !m.app.LogSilent {
 			log.Printf("[%s] Running pre-command: %s", m.app.Name, preCmd)
 		}
-		if err := m.runCommand(preCmd); err != nil {
-			log.Printf("[%s] Pre-command failed: %v", m.app.Name, err)
+		if err := m.runCommand(ctx, "pre_cmd", preCmd); err != nil {
+			if ctx.Err() != nil {
+				return m.cancelled()
+			}
+			m.commandFailed("Pre-command failed", err)
 			if m.app.StopOnError {
+				if !stopped && swapPath == "" {
+					m.stopForRestart()
+				}
 				return fmt.Errorf("pre-command failed: %w", err)
 			}
 		}
 	}
 
-	buildCmd := m.app.BuildCmd
-	if buildCmd == "" && m.app.Cmd != "" {
-		buildCmd = m.app.Cmd
-	}
-
-	if buildCmd != "" {
+	if !buildCmd.IsZero() {
 		if !m.app.LogSilent {
 			log.Printf("[%s] Building: %s", m.app.Name, buildCmd)
 		}
-		if err := m.runCommand(buildCmd); err != nil {
-			log.Printf("[%s] Build failed: %v", m.app.Name, err)
+		err := m.runBuild(ctx, buildCmd)
+		if ctx.Err() != nil {
+			return m.cancelled()
+		}
+		if err != nil {
+			m.commandFailed("Build failed", err)
+
+			if swapPath != "" {
+				m.keepPrevious()
+				return fmt.Errorf("build failed: %w", err)
+			}
+			if !stopped {
+				m.stopForRestart()
+				stopped = true
+			}
+			// a rerun starts the binary already in place
+			if freshPath != "" {
+				os.Remove(freshPath)
+				freshPath, skip = "", false
+			}
 
 			if !m.app.Rerun {
 				if m.app.StopOnError {
@@ -97,26 +266,27 @@ func (m *Manager) Restart() error {
 		}
 
 		// Make the built binary executable
-		// Try to extract the output path from the build command if Bin is not set
-		binaryPath := m.app.Bin
-		if binaryPath == "" {
-			// Try to extract from build command (e.g., "go build -o /path/to/binary")
-			binaryPath = extractOutputPath(buildCmd)
-			if binaryPath != "" {
-				log.Printf("[%s] Extracted binary path from build command: %s", m.app.Name, binaryPath)
-			}
-		} else {
-			log.Printf("[%s] Using configured binary path: %s", m.app.Name, binaryPath)
+		built := binaryPath
+		if swapPath != "" {
+			built = swapPath
+		} else if freshPath != "" {
+			built = freshPath
+		} else if m.app.Bin == "" && built != "" {
+			log.Printf("[%s] Extracted binary path from build command: %s", m.app.Name, built)
+		} else if built != "" {
+			log.Printf("[%s] Using configured binary path: %s", m.app.Name, built)
 		}
-		
-		if binaryPath != "" {
-			if err := os.Chmod(binaryPath, 0755); err != nil {
-				log.Printf("[%s] Warning: failed to make binary executable at %s: %v", m.app.Name, binaryPath, err)
+
+		if built != "" {
+			built = m.path(built)
+			if err := os.Chmod(built, 0755); err != nil {
+				log.Printf("[%s] Warning: failed to make binary executable at %s: %v", m.app.Name, built, err)
 			} else {
-				log.Printf("[%s] Set executable permissions on %s", m.app.Name, binaryPath)
+				log.Printf("[%s] Set executable permissions on %s", m.app.Name, built)
 			}
-			if m.app.CleanOnExit {
-				m.tmpFiles = append(m.tmpFiles, binaryPath)
+			// swapped binaries are removed once they are replaced
+			if installed := m.path(binaryPath); m.app.CleanOnExit && swapPath == "" && !slices.Contains(m.tmpFiles, installed) {
+				m.tmpFiles = append(m.tmpFiles, installed)
 			}
 		} else {
 			log.Printf("[%s] Warning: no binary path found to set permissions", m.app.Name)
@@ -127,14 +297,54 @@ func (m *Manager) Restart() error {
 		if !m.app.LogSilent {
 			log.Printf("[%s] Running post-command: %s", m.app.Name, postCmd)
 		}
-		if err := m.runCommand(postCmd); err != nil {
-			log.Printf("[%s] Post-command failed: %v", m.app.Name, err)
+		if err := m.runCommand(ctx, "post_cmd", postCmd); err != nil {
+			if ctx.Err() != nil {
+				return m.cancelled()
+			}
+			m.commandFailed("Post-command failed", err)
+			if swapPath != "" {
+				m.keepPrevious()
+				return fmt.Errorf("post-command failed: %w", err)
+			}
 			if m.app.StopOnError {
+				if !stopped {
+					m.stopForRestart()
+				}
 				return fmt.Errorf("post-command failed: %w", err)
 			}
 		}
 	}
 
+	// don't replace or start what has already been superseded
+	if ctx.Err() != nil {
+		return m.cancelled()
+	}
+
+	if skip {
+		built := swapPath
+		if built == "" {
+			built = freshPath
+		}
+		if pid, ok := m.unchanged(built); ok {
+			if !m.app.LogSilent {
+				log.Printf("[%s] Binary unchanged, keeping PID %d", m.app.Name, pid)
+			}
+			return ErrUnchanged
+		}
+	}
+
+	if swapPath != "" {
+		m.stopForRestart()
+		m.swapBinary(swapOf, swapPath)
+		swapped = true
+	} else if !stopped {
+		m.stopForRestart()
+	}
+	if freshPath != "" {
+		m.installBinary(freshPath, binaryPath)
+		freshPath = ""
+	}
+
 	if m.app.Delay > 0 {
 		if !m.app.LogSilent {
 			log.Printf("[%s] Waiting %dms before starting...", m.app.Name, m.app.Delay)
@@ -142,6 +352,10 @@ func (m *Manager) Restart() error {
 		time.Sleep(time.Duration(m.app.Delay) * time.Millisecond)
 	}
 
+	if ctx.Err() != nil {
+		return m.cancelled()
+	}
+
 	if err := m.Start(); err != nil {
 		log.Printf("[%s] Failed to start: %v", m.app.Name, err)
 		if m.app.StopOnError {
@@ -150,39 +364,301 @@ func (m *Manager) Restart() error {
 		return err
 	}
 
-	return nil
+	return m.waitReady(ctx)
 }
 
-func (m *Manager) runCommand(command string) error {
-	if command == "" {
+// stopForRestart stops the process before it is started again, forgetting
+// earlier crashes, and waits for kill_delay, or stop_delay without it.
+func (m *Manager) stopForRestart() {
+	m.resetCrashes()
+
+	if err := m.Stop(); err != nil {
+		log.Printf("[%s] Warning: failed to stop cleanly: %v", m.app.Name, err)
+	}
+
+	delay := m.stopDelay
+	if m.app.KillDelay != "" {
+		if duration, err := time.ParseDuration(m.app.KillDelay); err == nil {
+			delay = duration
+		}
+	}
+	if delay > 0 {
+		if !m.app.LogSilent {
+			log.Printf("[%s] Waiting %v after stop...", m.app.Name, delay)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// RestartWithoutBuild stops the process and starts it again with a freshly
+// loaded environment, skipping the hooks and the build.
+func (m *Manager) RestartWithoutBuild() error {
+	m.restartMu.Lock()
+	defer m.restartMu.Unlock()
+
+	// a restart requested meanwhile cancels the wait for readiness, without
+	// this restart superseding it in turn
+	m.mu.Lock()
+	gen := m.buildGen
+	m.mu.Unlock()
+	ctx, done, ok := m.beginBuild(gen)
+	if !ok {
+		return ErrSuperseded
+	}
+	defer done()
+
+	if !m.app.LogSilent {
+		log.Printf("[%s] Restarting without rebuild...", m.app.Name)
+	}
+	m.stopForRestart()
+
+	if err := m.Start(); err != nil {
+		log.Printf("[%s] Failed to start: %v", m.app.Name, err)
+		return err
+	}
+
+	return m.waitReady(ctx)
+}
+
+// waitReady blocks until the process that was just started passes its
+// readiness check, returning a *NotReadyError if it times out or the process
+// exits first. Without a readiness check the process is ready once it has
+// kept running for the start delay. It returns ErrSuperseded as soon as
+// restart is cancelled by a newer restart.
+func (m *Manager) waitReady(restart context.Context) error {
+	m.mu.Lock()
+	running, exited, cmd := m.running, m.exited, m.cmd
+	m.mu.Unlock()
+
+	if !running {
 		return nil
 	}
+	if m.readiness == nil {
+		// an exit meanwhile is reported, and handled, by the restart policy
+		select {
+		case <-exited:
+			return nil
+		case <-restart.Done():
+			return ErrSuperseded
+		case <-time.After(m.startDelay):
+		}
 
-	parts := strings.Fields(command)
-	if len(parts) == 0 {
-		return fmt.Errorf("empty command")
+		m.mu.Lock()
+		if m.exited == exited && m.running {
+			m.ready = true
+		}
+		m.mu.Unlock()
+		m.watchLiveness(cmd, exited)
+		return nil
 	}
 
-	cmd := exec.Command(parts[0], parts[1:]...)
-	cmd.Dir = "."
+	log.Printf("[%s] Waiting until ready (%s)...", m.app.Name, m.readiness.Probe)
 
-	cmd.Env = os.Environ()
-	for key, value := range m.app.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+	ctx, cancel := context.WithCancel(restart)
+	defer cancel()
+	go func() {
+		select {
+		case <-exited:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	started := time.Now()
+	if err := m.readiness.Wait(ctx); err != nil {
+		if restart.Err() != nil {
+			log.Printf("[%s] Stopped waiting until ready, newer changes arrived", m.app.Name)
+			return ErrSuperseded
+		}
+		if ctx.Err() != nil {
+			err = fmt.Errorf("process exited")
+		}
+		log.Printf("[%s] Not ready: %v", m.app.Name, err)
+		return &NotReadyError{Err: err}
+	}
+
+	m.mu.Lock()
+	// the process may have been replaced or stopped meanwhile
+	if m.exited == exited && m.running {
+		m.ready = true
+	}
+	m.mu.Unlock()
+
+	log.Printf("[%s] Ready after %v", m.app.Name, time.Since(started).Round(time.Millisecond))
+	m.watchLiveness(cmd, exited)
+	return nil
+}
+
+// supersedeBuild registers a new restart request, cancelling the build of
+// the restart in progress, and returns the request's generation.
+func (m *Manager) supersedeBuild() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.buildGen++
+	if m.buildCancel != nil {
+		log.Printf("[%s] Cancelling the build in progress, newer changes arrived", m.app.Name)
+		m.buildCancel()
+	}
+	return m.buildGen
+}
+
+// Close cancels the restart in progress and waits for it to return, and
+// refuses every restart requested later, so that no process is started once
+// the app is being stopped for good. Stop must still be called to stop the
+// process.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	m.buildGen++
+	if m.buildCancel != nil {
+		log.Printf("[%s] Cancelling the build in progress", m.app.Name)
+		m.buildCancel()
+		m.buildCancel = nil
 	}
+	m.mu.Unlock()
+
+	// restarts hold restartMu until they return
+	m.restartMu.Lock()
+	m.restartMu.Unlock()
+}
 
-	output, err := cmd.CombinedOutput()
+// beginBuild returns the context for the commands of restart gen, or false if
+// a newer restart has been requested meanwhile, or the manager was closed.
+// done must be called once the restart has finished.
+func (m *Manager) beginBuild(gen uint64) (ctx context.Context, done func(), ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed || gen != m.buildGen {
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.buildCancel = cancel
+	return ctx, func() {
+		cancel()
+		m.mu.Lock()
+		if m.buildGen == gen {
+			m.buildCancel = nil
+		}
+		m.mu.Unlock()
+	}, true
+}
+
+func (m *Manager) cancelled() error {
+	if !m.app.LogSilent {
+		log.Printf("[%s] Build cancelled", m.app.Name)
+	}
+	return ErrSuperseded
+}
+
+// runBuild runs the build command, killing it if it takes longer than the
+// build timeout.
+func (m *Manager) runBuild(ctx context.Context, buildCmd config.Command) error {
+	return m.runWithTimeout(ctx, "build", buildCmd)
+}
+
+// runWithTimeout runs command like runCommand, killing it if it takes longer
+// than the build timeout.
+func (m *Manager) runWithTimeout(ctx context.Context, stage string, command config.Command) error {
+	if m.buildTimeout <= 0 {
+		return m.runCommand(ctx, stage, command)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, m.buildTimeout)
+	defer cancel()
+
+	err := m.runCommand(timeoutCtx, stage, command)
+	if err != nil && ctx.Err() == nil && timeoutCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v (build_timeout)", m.buildTimeout)
+	}
+	return err
+}
+
+// runCommand runs a hook or build command in its own process group, which is
+// killed as a whole when ctx is done. Its output is streamed line by line,
+// labelled with stage, and the last lines are kept for commandFailed.
+func (m *Manager) runCommand(ctx context.Context, stage string, command config.Command) error {
+	parts := command.Args(m.app.Shell)
+	if len(parts) == 0 {
+		return nil
+	}
+
+	env, err := m.environ()
 	if err != nil {
-		return fmt.Errorf("command failed: %v\nOutput:\n%s", err, string(output))
+		return err
 	}
 
-	if !m.app.LogSilent && len(output) > 0 {
-		log.Printf("[%s] %s", m.app.Name, string(output))
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	cmd.Dir = m.app.WorkingDir
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
 	}
+	// don't wait for the output of children that outlive the group
+	cmd.WaitDelay = time.Second
+
+	m.outputMu.Lock()
+	m.buildOutput = nil
+	m.outputMu.Unlock()
+
+	// stdout and stderr share the pipe to keep their lines in order
+	reader, writer := io.Pipe()
+	cmd.Stdout = writer
+	cmd.Stderr = writer
 
+	streamed := make(chan struct{})
+	go func() {
+		defer close(streamed)
+		m.streamBuildOutput(reader, stage)
+	}()
+
+	err = cmd.Run()
+	writer.Close()
+	<-streamed
+
+	if err != nil {
+		return fmt.Errorf("command failed: %w", err)
+	}
 	return nil
 }
 
+// streamBuildOutput prints the output of a hook or build command and keeps
+// its last outputLines lines.
+func (m *Manager) streamBuildOutput(pipe io.Reader, stage string) {
+	scanner := bufio.NewScanner(pipe)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		m.outputMu.Lock()
+		m.buildOutput = append(m.buildOutput, line)
+		if len(m.buildOutput) > outputLines {
+			m.buildOutput = m.buildOutput[len(m.buildOutput)-outputLines:]
+		}
+		m.outputMu.Unlock()
+
+		if !m.app.LogSilent {
+			log.Printf("[%s] %s | %s", m.app.Name, stage, line)
+		}
+	}
+	// drain the rest so that the command is not blocked writing
+	io.Copy(io.Discard, pipe)
+}
+
+// commandFailed reports that the last hook or build command failed, with the
+// last lines it printed.
+func (m *Manager) commandFailed(summary string, err error) {
+	log.Printf("[%s] %s: %v", m.app.Name, summary, err)
+
+	m.outputMu.Lock()
+	lines := append([]string{}, m.buildOutput...)
+	m.outputMu.Unlock()
+
+	m.logLines(lines)
+}
+
 /*
 	func (m *Manager) build() error {
 		if m.app.BuildCmd == "" {
@@ -223,10 +699,11 @@ func (m *Manager) Start() error {
 
 	if m.app.Bin != "" {
 
-		cmdParts = append([]string{m.app.Bin}, m.app.Args...)
-	} else if m.app.RunCmd != "" {
+		bin := m.swappedCommand(config.Command{Argv: []string{m.app.Bin}})
+		cmdParts = append(bin.Argv, m.app.Args...)
+	} else if !m.app.RunCmd.IsZero() {
 
-		cmdParts = strings.Fields(m.app.RunCmd)
+		cmdParts = m.swappedCommand(m.app.RunCmd).Args(m.app.Shell)
 	} else {
 		if !m.app.LogSilent {
 			log.Printf("[%s] No run command specified, skipping", m.app.Name)
@@ -238,49 +715,113 @@ func (m *Manager) Start() error {
 		return fmt.Errorf("empty run command")
 	}
 
+	env, err := m.environ()
+	if err != nil {
+		return err
+	}
+
+	m.ready = false
+	m.halted = false
+	m.outputMu.Lock()
+	m.output = nil
+	m.outputMu.Unlock()
+	if resetter, ok := m.readinessProbe().(probe.Resetter); ok {
+		resetter.Reset()
+	}
+
+	m.runningDigest = ""
+	if m.app.SkipUnchanged {
+		m.runningDigest = m.digest(m.runningBinary(), env)
+	}
+
 	m.cmd = exec.Command(cmdParts[0], cmdParts[1:]...)
-	m.cmd.Dir = "."
+	m.cmd.Dir = m.app.WorkingDir
+	m.cmd.Env = env
 
 	m.cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
 
-	m.cmd.Env = os.Environ()
-	for key, value := range m.app.Env {
-		m.cmd.Env = append(m.cmd.Env, fmt.Sprintf("%s=%s", key, value))
-	}
-
-	stdout, err := m.cmd.StdoutPipe()
+	// the process writes to the pipes directly, so that Wait returns once it
+	// exits even if children it left behind keep them open
+	stdout, stdoutWriter, err := os.Pipe()
 	if err != nil {
 		return fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
-
-	stderr, err := m.cmd.StderrPipe()
+	stderr, stderrWriter, err := os.Pipe()
 	if err != nil {
+		stdout.Close()
+		stdoutWriter.Close()
 		return fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
+	m.cmd.Stdout = stdoutWriter
+	m.cmd.Stderr = stderrWriter
 
-	if err := m.cmd.Start(); err != nil {
+	err = m.cmd.Start()
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
 		return fmt.Errorf("failed to start process: %w", err)
 	}
 
 	m.running = true
+	m.startedAt = time.Now()
 
-	go m.streamOutput(stdout, "stdout")
-	go m.streamOutput(stderr, "stderr")
+	cmd, startedAt := m.cmd, m.startedAt
+	exited := make(chan struct{})
+	m.exited = exited
+
+	var output sync.WaitGroup
+	output.Add(2)
+	go func() {
+		defer output.Done()
+		defer stdout.Close()
+		m.streamOutput(stdout, "stdout")
+	}()
+	go func() {
+		defer output.Done()
+		defer stderr.Close()
+		m.streamOutput(stderr, "stderr")
+	}()
+	outputDone := make(chan struct{})
+	go func() {
+		output.Wait()
+		close(outputDone)
+	}()
 
 	go func() {
-		err := m.cmd.Wait()
+		err := cmd.Wait()
+		// let the last lines be printed before the exit is reported, unless
+		// children left behind keep the output open
+		select {
+		case <-outputDone:
+		case <-time.After(outputGrace):
+		}
+		close(exited)
+
+		// Stop clears m.cmd before the lock is released, so a process that
+		// is still current exited on its own
 		m.mu.Lock()
-		m.running = false
-		m.cmd = nil
+		unexpected := m.cmd == cmd
+		if unexpected {
+			m.running = false
+			m.ready = false
+			m.cmd = nil
+		}
 		m.mu.Unlock()
 
+		if !unexpected {
+			return
+		}
+
 		if err != nil {
 			log.Printf("[%s] Process exited with error: %v", m.app.Name, err)
 		} else {
 			log.Printf("[%s] Process exited normally", m.app.Name)
 		}
+		m.handleExit(err, startedAt)
 	}()
 
 	log.Printf("[%s] Started successfully (PID: %d)", m.app.Name, m.cmd.Process.Pid)
@@ -291,6 +832,12 @@ func (m *Manager) Stop() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.halted = true
+	if m.crashes.retry != nil {
+		m.crashes.retry.Stop()
+		m.crashes.retry = nil
+	}
+
 	if !m.running || m.cmd == nil || m.cmd.Process == nil {
 		return nil
 	}
@@ -317,38 +864,85 @@ func (m *Manager) Stop() error {
 		}
 	}
 
-	done := make(chan error, 1)
-	go func() {
-		_, err := m.cmd.Process.Wait()
-		done <- err
-	}()
+	done := m.exited
 
 	select {
 	case <-done:
 		log.Printf("[%s] Process stopped gracefully", m.app.Name)
-	case <-time.After(5 * time.Second):
-
-		log.Printf("[%s] Process didn't stop gracefully, force killing...", m.app.Name)
+	case <-time.After(m.stopTimeout):
+		log.Printf("[%s] Process didn't stop within %v, force killing...", m.app.Name, m.stopTimeout)
 		if pgid, err := syscall.Getpgid(m.cmd.Process.Pid); err == nil {
 			syscall.Kill(-pgid, syscall.SIGKILL)
 		} else {
 			m.cmd.Process.Kill()
 		}
-		<-done
+		select {
+		case <-done:
+		case <-time.After(killTimeout):
+			log.Printf("[%s] Warning: process %d still not reaped %v after SIGKILL, giving up on it", m.app.Name, m.cmd.Process.Pid, killTimeout)
+		}
 	}
 
 	m.running = false
+	m.ready = false
 	m.cmd = nil
 	return nil
 }
 
+// environ builds the environment for the app's commands. Wisp's own
+// environment comes first, then each env_file in order, then the inline env
+// table; later sources override earlier ones. Env files are re-read every
+// time so edits take effect on the next restart.
+func (m *Manager) environ() ([]string, error) {
+	env := os.Environ()
+	if len(m.app.EnvFile) == 0 && len(m.app.Env) == 0 {
+		return env, nil
+	}
+
+	values := make(map[string]string, len(env))
+	for _, kv := range env {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			values[key] = value
+		}
+	}
+	lookup := func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+
+	for _, path := range m.app.EnvFile {
+		vars, err := config.LoadEnvFile(path, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load env file: %w", err)
+		}
+		for key, value := range vars {
+			values[key] = value
+			env = append(env, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+
+	for key, value := range m.app.Env {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	return env, nil
+}
+
 func (m *Manager) streamOutput(pipe io.ReadCloser, streamType string) {
+	observer, _ := m.readinessProbe().(probe.LineObserver)
+
 	scanner := bufio.NewScanner(pipe)
 	for scanner.Scan() {
+		line := scanner.Text()
+		if m.recordOutput(line) {
+			continue
+		}
+		if observer != nil {
+			observer.Observe(line)
+		}
 		if m.app.LogSilent {
 			continue
 		}
-		line := scanner.Text()
 		if streamType == "stderr" {
 			log.Printf("[%s] %s", m.app.Name, line)
 		} else {
@@ -360,6 +954,8 @@ func (m *Manager) streamOutput(pipe io.ReadCloser, streamType string) {
 			log.Printf("[%s] Error reading %s: %v", m.app.Name, streamType, err)
 		}
 	}
+	// drain the rest so that the process is not blocked writing
+	io.Copy(io.Discard, pipe)
 }
 
 func (m *Manager) CleanUp() {
@@ -375,8 +971,13 @@ func (m *Manager) CleanUp() {
 		}
 	}
 
-	if m.app.TmpDir != "" && m.app.TmpDir != "." && m.app.TmpDir != "/" {
-		os.RemoveAll(m.app.TmpDir)
+	// tmp_dir defaults to /tmp, which is not wisp's to remove
+	tmpDir := m.path(m.app.TmpDir)
+	if tmpDir != "" {
+		tmpDir = filepath.Clean(tmpDir)
+	}
+	if tmpDir != "" && tmpDir != m.app.WorkingDir && tmpDir != "/" && tmpDir != "/tmp" && tmpDir != filepath.Clean(os.TempDir()) {
+		os.RemoveAll(tmpDir)
 	}
 }
 
@@ -386,10 +987,81 @@ func (m *Manager) IsRunning() bool {
 	return m.running
 }
 
+// IsReady reports whether the process is running and has passed its
+// readiness check.
+func (m *Manager) IsReady() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ready
+}
+
+// Status describes the state of the process for display: "stopped",
+// "starting" while waiting for the readiness check, or "ready".
+func (m *Manager) Status() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case !m.running:
+		return "stopped"
+	case !m.ready:
+		return "starting"
+	default:
+		return "ready"
+	}
+}
+
+// ReadinessCheck describes the readiness check, or returns "" if there is
+// none.
+func (m *Manager) ReadinessCheck() string {
+	if p := m.readinessProbe(); p != nil {
+		return p.String()
+	}
+	return ""
+}
+
+func (m *Manager) readinessProbe() probe.Probe {
+	if m.readiness == nil {
+		return nil
+	}
+	return m.readiness.Probe
+}
+
+func (m *Manager) buildCmd() config.Command {
+	if m.app.BuildCmd.IsZero() && !m.app.Cmd.IsZero() {
+		return m.app.Cmd
+	}
+	return m.app.BuildCmd
+}
+
+// binaryPath returns the path the build writes the binary to: bin, or the
+// output of the build command (e.g., "go build -o /path/to/binary").
+func (m *Manager) binaryPath() string {
+	if m.app.Bin != "" {
+		return m.app.Bin
+	}
+	return extractOutputPath(m.buildCmd())
+}
+
+// BinaryPath returns where the build writes the app's binary, or "" if it
+// is not known.
+func (m *Manager) BinaryPath() string {
+	return m.path(m.binaryPath())
+}
+
+// path resolves a path used by the app's commands, which is relative to its
+// working directory.
+func (m *Manager) path(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(m.app.WorkingDir, p)
+}
+
 // extractOutputPath tries to extract the output file path from a build command
 // For example: "go build -o /tmp/binary ./cmd/app" returns "/tmp/binary"
-func extractOutputPath(buildCmd string) string {
-	parts := strings.Fields(buildCmd)
+func extractOutputPath(buildCmd config.Command) string {
+	parts := buildCmd.Words()
 	for i := 0; i < len(parts)-1; i++ {
 		if parts[i] == "-o" {
 			return parts[i+1]
@@ -0,0 +1,189 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/mktcz/wisp/internal/config"
+)
+
+const (
+	// maxBackoff caps the delay between restarts after crashes.
+	maxBackoff = time.Minute
+	// outputLines is how many lines of output are kept for crash and build
+	// failure summaries.
+	outputLines = 20
+)
+
+// crashHistory tracks the exits of an app that were not requested by wisp.
+type crashHistory struct {
+	times []time.Time
+	// consecutive counts the restarts since the app last ran for a whole
+	// crash window.
+	consecutive int
+	retry       *time.Timer
+}
+
+// handleExit applies the app's restart policy after its process, started at
+// startedAt, exited without being stopped.
+func (m *Manager) handleExit(err error, startedAt time.Time) {
+	switch m.app.Restart {
+	case config.RestartAlways:
+	case config.RestartOnFailure:
+		if err == nil {
+			return
+		}
+	default:
+		return
+	}
+
+	window := parseDuration(m.app.CrashWindow, time.Minute)
+	now := time.Now()
+
+	m.mu.Lock()
+	if m.halted {
+		m.mu.Unlock()
+		return
+	}
+	if now.Sub(startedAt) >= window {
+		m.crashes.consecutive = 0
+	}
+	m.crashes.consecutive++
+	recent := m.crashes.times[:0]
+	for _, t := range m.crashes.times {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	m.crashes.times = append(recent, now)
+	attempt, crashes := m.crashes.consecutive, len(m.crashes.times)
+	m.mu.Unlock()
+
+	if m.app.CrashLimit > 0 && crashes >= m.app.CrashLimit {
+		m.reportCrash(fmt.Sprintf("Crash loop detected: exited %d times within %v, not restarting until the next change", crashes, window), err)
+		return
+	}
+	if m.app.MaxRestarts > 0 && attempt > m.app.MaxRestarts {
+		m.reportCrash(fmt.Sprintf("Gave up after %d restarts, not restarting until the next change", m.app.MaxRestarts), err)
+		return
+	}
+
+	backoff := parseDuration(m.app.RestartBackoff, time.Second)
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxBackoff)
+
+	log.Printf("[%s] Restarting in %v (attempt %d)...", m.app.Name, backoff, attempt)
+
+	m.mu.Lock()
+	if !m.halted {
+		m.crashes.retry = time.AfterFunc(backoff, m.restartAfterCrash)
+	}
+	m.mu.Unlock()
+}
+
+// restartAfterCrash starts the process again once the backoff has passed,
+// unless wisp stopped or restarted the app meanwhile.
+func (m *Manager) restartAfterCrash() {
+	m.mu.Lock()
+	halted := m.halted
+	m.crashes.retry = nil
+	m.mu.Unlock()
+
+	if halted {
+		return
+	}
+
+	if err := m.Start(); err != nil {
+		log.Printf("[%s] Failed to start: %v", m.app.Name, err)
+		m.handleExit(err, time.Now())
+		return
+	}
+	m.waitReady(context.Background())
+}
+
+// resetCrashes forgets earlier crashes and cancels a pending restart, for
+// when the app is restarted on purpose.
+func (m *Manager) resetCrashes() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.crashes.retry != nil {
+		m.crashes.retry.Stop()
+	}
+	m.crashes = crashHistory{}
+}
+
+// reportCrash prints why the app is no longer restarted, how it last exited
+// and the last lines it printed.
+func (m *Manager) reportCrash(summary string, err error) {
+	log.Printf("[%s] %s", m.app.Name, summary)
+	log.Printf("[%s]   last exit: %s", m.app.Name, describeExit(err))
+
+	m.logLines(m.lastOutput())
+}
+
+// logLines prints the last lines of output for a failure summary.
+func (m *Manager) logLines(lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	log.Printf("[%s]   last %d lines of output:", m.app.Name, len(lines))
+	for _, line := range lines {
+		log.Printf("[%s]   | %s", m.app.Name, line)
+	}
+}
+
+func describeExit(err error) string {
+	if err == nil {
+		return "exit code 0"
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return fmt.Sprintf("killed by signal %d (%v)", status.Signal(), status.Signal())
+		}
+		return fmt.Sprintf("exit code %d", exitErr.ExitCode())
+	}
+
+	return err.Error()
+}
+
+// recordOutput keeps the last outputLines lines the process printed. While a
+// goroutine dump is being captured the line goes into the dump instead, and
+// recordOutput reports that it was captured.
+func (m *Manager) recordOutput(line string) bool {
+	m.outputMu.Lock()
+	defer m.outputMu.Unlock()
+
+	if m.dump != nil {
+		fmt.Fprintln(m.dump, line)
+		return true
+	}
+
+	m.output = append(m.output, line)
+	if len(m.output) > outputLines {
+		m.output = m.output[len(m.output)-outputLines:]
+	}
+	return false
+}
+
+func (m *Manager) lastOutput() []string {
+	m.outputMu.Lock()
+	defer m.outputMu.Unlock()
+	return append([]string{}, m.output...)
+}
+
+func parseDuration(value string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
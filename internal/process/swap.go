@@ -0,0 +1,91 @@
+package process
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mktcz/wisp/internal/config"
+)
+
+// SetBuildDir sets the directory build_swap builds into. Without it the
+// binaries are built next to the configured one.
+func (m *Manager) SetBuildDir(dir string) {
+	m.buildDir = dir
+}
+
+// swapBuildCmd rewrites buildCmd to write the binary, configured as output,
+// to a fresh path for restart gen instead, so that the running process is left
+// alone while it builds. It returns false if output is not in buildCmd.
+func (m *Manager) swapBuildCmd(buildCmd config.Command, output string, gen uint64) (config.Command, string, bool) {
+	if output == "" {
+		return buildCmd, "", false
+	}
+
+	dir := m.buildDir
+	if dir == "" {
+		dir = filepath.Dir(m.path(output))
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-build-%d", m.app.Name, gen))
+
+	swapped, ok := buildCmd.ReplaceWord(output, path)
+	return swapped, path, ok
+}
+
+// runWord returns the word of the run command, or bin, that names the binary
+// the build writes to output, comparing both once resolved against the
+// working directory. It returns false if the run command does not run it.
+func (m *Manager) runWord(output string) (string, bool) {
+	if output == "" {
+		return "", false
+	}
+
+	words := m.app.RunCmd.Words()
+	if m.app.Bin != "" {
+		words = []string{m.app.Bin}
+	}
+	target := filepath.Clean(m.path(output))
+	for _, word := range words {
+		if word != "" && filepath.Clean(m.path(word)) == target {
+			return word, true
+		}
+	}
+	return "", false
+}
+
+// swapBinary makes the process run the binary at path in place of runWord,
+// the word of the run command naming the configured binary, from now on,
+// and removes the binary it replaces.
+func (m *Manager) swapBinary(runWord, path string) {
+	m.mu.Lock()
+	previous := m.binary
+	m.binary, m.binaryOf = path, runWord
+	m.mu.Unlock()
+
+	if previous == "" || previous == path {
+		return
+	}
+	if err := os.Remove(previous); err != nil && !os.IsNotExist(err) {
+		log.Printf("[%s] Warning: failed to remove the previous binary %s: %v", m.app.Name, previous, err)
+	}
+}
+
+// keepPrevious reports that a failed build_swap build left the previous
+// version of the app running.
+func (m *Manager) keepPrevious() {
+	if m.IsRunning() {
+		log.Printf("[%s] Keeping the previous version running", m.app.Name)
+	}
+}
+
+// swappedCommand returns command with the configured binary path replaced by
+// the binary of the last successful build_swap build. It must be called with
+// m.mu held.
+func (m *Manager) swappedCommand(command config.Command) config.Command {
+	if m.binary == "" {
+		return command
+	}
+	swapped, _ := command.ReplaceWord(m.binaryOf, m.binary)
+	return swapped
+}
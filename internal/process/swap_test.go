@@ -0,0 +1,92 @@
+package process
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mktcz/wisp/internal/config"
+)
+
+func TestRunWord(t *testing.T) {
+	tests := []struct {
+		name   string
+		app    config.App
+		output string
+		word   string
+		ok     bool
+	}{
+		{
+			name:   "run command without the leading dot",
+			app:    config.App{RunCmd: config.Command{Line: "bin/app"}},
+			output: "./bin/app",
+			word:   "bin/app",
+			ok:     true,
+		},
+		{
+			name:   "run command with arguments",
+			app:    config.App{RunCmd: config.Command{Line: "./bin/app -port 8080"}},
+			output: "bin/app",
+			word:   "./bin/app",
+			ok:     true,
+		},
+		{
+			name:   "absolute run command",
+			app:    config.App{RunCmd: config.Command{Argv: []string{"/srv/bin/app"}}},
+			output: "./bin/app",
+			word:   "/srv/bin/app",
+			ok:     true,
+		},
+		{
+			name:   "bin",
+			app:    config.App{Bin: "./bin/app", RunCmd: config.Command{Line: "other"}},
+			output: "./bin/app",
+			word:   "./bin/app",
+			ok:     true,
+		},
+		{
+			name:   "run command running another binary",
+			app:    config.App{RunCmd: config.Command{Line: "bin/other"}},
+			output: "./bin/app",
+		},
+		{
+			name:   "unknown output",
+			app:    config.App{RunCmd: config.Command{Line: "bin/app"}},
+			output: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := tt.app
+			app.Name = "app"
+			app.WorkingDir = "/srv"
+
+			word, ok := NewManager(&app).runWord(tt.output)
+			if word != tt.word || ok != tt.ok {
+				t.Errorf("runWord(%q) = %q, %v, want %q, %v", tt.output, word, ok, tt.word, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSwappedRunCommand(t *testing.T) {
+	app := &config.App{
+		Name:       "app",
+		WorkingDir: "/srv",
+		Shell:      []string{"/bin/sh", "-c"},
+		RunCmd:     config.Command{Line: "bin/app -v"},
+	}
+	m := NewManager(app)
+
+	word, ok := m.runWord("./bin/app")
+	if !ok {
+		t.Fatal("runWord did not find the binary in the run command")
+	}
+	m.swapBinary(word, "/tmp/session/app-build-1")
+
+	got := m.swappedCommand(app.RunCmd).Args(app.Shell)
+	want := []string{"/bin/sh", "-c", "/tmp/session/app-build-1 -v"}
+	if !slices.Equal(got, want) {
+		t.Errorf("swapped run command = %q, want %q", got, want)
+	}
+}
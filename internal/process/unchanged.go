@@ -0,0 +1,99 @@
+package process
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/mktcz/wisp/internal/config"
+)
+
+// ErrUnchanged is returned by Restart when the build produced the binary the
+// process is already running, with the same arguments and environment, so
+// the process was left running.
+var ErrUnchanged = errors.New("binary unchanged")
+
+// commandLine returns the app's command line as configured, before
+// build_swap replaces the binary path in it.
+func (m *Manager) commandLine() []string {
+	if m.app.Bin != "" {
+		return append([]string{m.app.Bin}, m.app.Args...)
+	}
+	return m.app.RunCmd.Args(m.app.Shell)
+}
+
+// runningBinary returns the binary the process is started from, or "" if it
+// is not known. It must be called with m.mu held.
+func (m *Manager) runningBinary() string {
+	if m.binary != "" {
+		return m.binary
+	}
+	return m.path(m.binaryPath())
+}
+
+// digest identifies what a process started from binary with env runs: the
+// contents of the binary, the command line and the environment. It returns
+// "" if the binary cannot be read.
+func (m *Manager) digest(binary string, env []string) string {
+	if binary == "" {
+		return ""
+	}
+
+	h := sha256.New()
+	if err := hashBinary(h, binary); err != nil {
+		return ""
+	}
+	// the environment is partly built from a map, in no particular order
+	for _, words := range [][]string{m.commandLine(), slices.Sorted(slices.Values(env))} {
+		h.Write([]byte{0})
+		for _, word := range words {
+			h.Write([]byte(word))
+			h.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// unchanged reports whether starting the app from binary would run what the
+// running process already runs, and returns the process's PID if so.
+func (m *Manager) unchanged(binary string) (int, bool) {
+	env, err := m.environ()
+	if err != nil {
+		return 0, false
+	}
+	digest := m.digest(binary, env)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// a halted process, such as one that failed its liveness check, is
+	// replaced regardless
+	if digest == "" || digest != m.runningDigest || !m.running || m.halted || m.cmd == nil {
+		return 0, false
+	}
+	return m.cmd.Process.Pid, true
+}
+
+// freshBuildCmd rewrites buildCmd to write the binary, configured as output,
+// to a fresh path next to it for restart gen, so that the running binary is
+// only replaced once the build is known to have changed it. It returns false
+// if output is not in buildCmd.
+func (m *Manager) freshBuildCmd(buildCmd config.Command, output string, gen uint64) (config.Command, string, bool) {
+	// next to the binary, so that it can be moved over it
+	path := filepath.Join(filepath.Dir(m.path(output)), fmt.Sprintf(".%s-build-%d", m.app.Name, gen))
+	fresh, ok := buildCmd.ReplaceWord(output, path)
+	return fresh, path, ok
+}
+
+// installBinary moves the binary built at path over output, the binary the
+// app is configured to run.
+func (m *Manager) installBinary(path, output string) {
+	if err := os.Rename(path, m.path(output)); err != nil {
+		log.Printf("[%s] Warning: failed to replace %s with the new build: %v", m.app.Name, output, err)
+	}
+}
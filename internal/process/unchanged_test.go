@@ -0,0 +1,75 @@
+package process
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mktcz/wisp/internal/config"
+)
+
+// pid returns the PID of the process m runs.
+func pid(m *Manager) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cmd.Process.Pid
+}
+
+func TestRestartUnchangedBinary(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(dir, "app.sh")
+	write := func(script string) {
+		t.Helper()
+		if err := os.WriteFile(source, []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("#!/bin/sh\nexec sleep 30\n")
+
+	app := &config.App{
+		Name:          "app",
+		WorkingDir:    dir,
+		BuildCmd:      config.Command{Argv: []string{"cp", "app.sh", "./bin/app"}},
+		Bin:           "./bin/app",
+		SkipUnchanged: true,
+		StopTimeout:   "1s",
+	}
+	m := NewManager(app)
+	if err := m.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Stop() })
+	started := pid(m)
+
+	if err := m.Restart(); !errors.Is(err, ErrUnchanged) {
+		t.Fatalf("restart with an identical build = %v, want %v", err, ErrUnchanged)
+	}
+	if pid(m) != started {
+		t.Errorf("the process was replaced by an identical build")
+	}
+
+	write("#!/bin/sh\nexec sleep 31\n")
+	if err := m.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if pid(m) == started {
+		t.Errorf("the process was not replaced by a different build")
+	}
+	built, err := os.ReadFile(filepath.Join(dir, "bin", "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(built) != "#!/bin/sh\nexec sleep 31\n" {
+		t.Errorf("the binary was not replaced by the new build")
+	}
+
+	// fresh builds are moved or removed
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "bin", ".app-build-*"))
+	if len(leftovers) > 0 {
+		t.Errorf("builds left behind: %q", leftovers)
+	}
+}
@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mktcz/wisp/internal/config"
+	"github.com/mktcz/wisp/internal/godeps"
+	"github.com/mktcz/wisp/internal/watcher"
+)
+
+// watchGoDeps makes the watcher report only changes to the local packages
+// the app's main package depends on, instead of everything in watch_dir.
+func watchGoDeps(name string, app *config.App, fileWatcher *watcher.Watcher) (*godeps.Graph, error) {
+	buildCmd := app.BuildCmd
+	if buildCmd.IsZero() {
+		buildCmd = app.Cmd
+	}
+	dir, pkg, tags := godeps.MainPackage(buildCmd.Words())
+	if app.GoPackage != "" {
+		dir, pkg = "", app.GoPackage
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(app.WorkingDir, dir)
+	}
+
+	env := os.Environ()
+	for key, value := range app.Env {
+		env = append(env, key+"="+value)
+	}
+
+	graph, err := godeps.Load(dir, pkg, tags, env)
+	if err != nil {
+		return nil, err
+	}
+	if err := fileWatcher.SetDirs(graph.Dirs()...); err != nil {
+		return nil, err
+	}
+	fileWatcher.SetFilter(graph.Contains)
+
+	log.Printf("[%s] Watching %d local packages imported by %s", name, graph.Packages(), pkg)
+	return graph, nil
+}
+
+// refreshGoDeps lists the app's dependencies again once go.mod, go.work or
+// imports have changed, and watches the packages it now depends on.
+func refreshGoDeps(name string, graph *godeps.Graph, fileWatcher *watcher.Watcher) error {
+	before := graph.Packages()
+	if err := graph.Reload(); err != nil {
+		return fmt.Errorf("failed to list dependencies: %w", err)
+	}
+	if err := fileWatcher.SetDirs(graph.Dirs()...); err != nil {
+		return err
+	}
+
+	if after := graph.Packages(); after != before {
+		log.Printf("[%s] Dependencies changed, now watching %d local packages", name, after)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"slices"
+	"syscall"
+
+	"github.com/mktcz/wisp/internal/config"
+	"github.com/mktcz/wisp/internal/glob"
+	"github.com/mktcz/wisp/internal/process"
+)
+
+// changeAction is what a change does to an app. A batch of changes takes the
+// strongest action of its files.
+type changeAction int
+
+const (
+	actionIgnore changeAction = iota
+	actionRun
+	actionSignal
+	actionRestart
+	actionRebuild
+)
+
+var changeActions = map[string]changeAction{
+	config.ActionIgnore:  actionIgnore,
+	config.ActionRun:     actionRun,
+	config.ActionSignal:  actionSignal,
+	config.ActionRestart: actionRestart,
+	config.ActionRebuild: actionRebuild,
+}
+
+type changeRule struct {
+	patterns []*glob.Pattern
+	action   changeAction
+	signal   syscall.Signal
+	run      config.Command
+}
+
+// changeRules decides what a batch of changes does to an app, following its
+// on_change rules.
+type changeRules struct {
+	// dir is the working directory, which the patterns are relative to.
+	dir   string
+	rules []*changeRule
+}
+
+// changePlan is what a batch of changes does: its action, along with the
+// rules that asked for it, which hold the signals to send or the commands
+// to run.
+type changePlan struct {
+	action changeAction
+	rules  []*changeRule
+}
+
+func newChangeRules(app *config.App) (*changeRules, error) {
+	c := &changeRules{dir: app.WorkingDir}
+	for i, rule := range app.OnChange {
+		action, ok := changeActions[rule.Action]
+		if !ok {
+			return nil, fmt.Errorf("on_change rule %d: unknown action %q", i+1, rule.Action)
+		}
+		compiled := &changeRule{action: action, run: rule.Run}
+		if action == actionSignal {
+			sig, err := config.ParseSignal(rule.Signal)
+			if err != nil {
+				return nil, fmt.Errorf("on_change rule %d: %w", i+1, err)
+			}
+			compiled.signal = sig
+		}
+		for _, pattern := range rule.Paths {
+			p, err := glob.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("on_change rule %d: invalid pattern %q: %w", i+1, pattern, err)
+			}
+			compiled.patterns = append(compiled.patterns, p)
+		}
+		c.rules = append(c.rules, compiled)
+	}
+	return c, nil
+}
+
+// plan returns what a change to paths does. Each path takes the action of
+// the first rule matching it, or a rebuild if none does.
+func (c *changeRules) plan(paths []string) changePlan {
+	plan := changePlan{action: actionIgnore}
+	for _, path := range paths {
+		rule := c.match(path)
+		action := actionRebuild
+		if rule != nil {
+			action = rule.action
+		}
+
+		if action > plan.action {
+			plan = changePlan{action: action}
+		}
+		if action == plan.action && rule != nil && !slices.Contains(plan.rules, rule) {
+			plan.rules = append(plan.rules, rule)
+		}
+	}
+	return plan
+}
+
+func (c *changeRules) match(changed string) *changeRule {
+	rel, err := filepath.Rel(c.dir, changed)
+	if err != nil {
+		rel = changed
+	}
+	rel = filepath.ToSlash(rel)
+
+	for _, rule := range c.rules {
+		for _, pattern := range rule.patterns {
+			// a pattern matching a directory applies to everything in it
+			for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+				if pattern.Match(p) {
+					return rule
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// applyChanges carries out what a batch of changes does to an app.
+func (r *Runner) applyChanges(appName string, manager *process.Manager, plan changePlan) {
+	switch plan.action {
+	case actionRebuild:
+		r.rebuild(appName, manager)
+
+	case actionRestart:
+		if err := manager.RestartWithoutBuild(); err != nil {
+			if !errors.Is(err, process.ErrSuperseded) {
+				log.Printf("[%s] Restart failed: %v", appName, err)
+			}
+			return
+		}
+		r.restartDependents(appName)
+
+	case actionSignal:
+		sent := make(map[syscall.Signal]bool)
+		for _, rule := range plan.rules {
+			if sent[rule.signal] {
+				continue
+			}
+			sent[rule.signal] = true
+			if err := manager.Signal(rule.signal); err != nil && !errors.Is(err, process.ErrNotRunning) {
+				log.Printf("[%s] Failed to send %v: %v", appName, rule.signal, err)
+			}
+		}
+
+	case actionRun:
+		for _, rule := range plan.rules {
+			if err := manager.RunCommand(rule.run); err != nil {
+				return
+			}
+		}
+	}
+}
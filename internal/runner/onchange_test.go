@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/mktcz/wisp/internal/config"
+)
+
+func TestChangeRulesPlan(t *testing.T) {
+	const dir = "/srv/app"
+	app := &config.App{
+		Name:       "app",
+		WorkingDir: dir,
+		OnChange: []config.ChangeRule{
+			{Paths: []string{"docs", "*.md"}, Action: config.ActionIgnore},
+			{Paths: []string{"templates/**/*.html"}, Action: config.ActionRun, Run: config.Command{Line: "make templates"}},
+			{Paths: []string{"static/*.css"}, Action: config.ActionRun, Run: config.Command{Line: "make css"}},
+			{Paths: []string{"config/*.yaml"}, Action: config.ActionSignal, Signal: "SIGHUP"},
+			{Paths: []string{"/.env"}, Action: config.ActionRestart},
+			// shadowed by the first rule for Markdown files
+			{Paths: []string{"CHANGELOG.md"}, Action: config.ActionRebuild},
+			{Paths: []string{`weird\*.go`}, Action: config.ActionIgnore},
+		},
+	}
+	rules, err := newChangeRules(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		paths   []string
+		action  changeAction
+		matched []int
+	}{
+		{name: "no rule", paths: []string{"main.go"}, action: actionRebuild},
+		{name: "nothing", action: actionIgnore},
+		{name: "ignored", paths: []string{"README.md"}, action: actionIgnore, matched: []int{0}},
+		{name: "ignored at any depth", paths: []string{"pkg/api/README.md"}, action: actionIgnore, matched: []int{0}},
+		{name: "directory", paths: []string{"docs/guide/intro.txt"}, action: actionIgnore, matched: []int{0}},
+		{name: "first rule wins", paths: []string{"CHANGELOG.md"}, action: actionIgnore, matched: []int{0}},
+		{name: "double star", paths: []string{"templates/a/b/page.html"}, action: actionRun, matched: []int{1}},
+		{name: "double star without directories", paths: []string{"templates/page.html"}, action: actionRun, matched: []int{1}},
+		{name: "star stops at slashes", paths: []string{"static/vendor/site.css"}, action: actionRebuild},
+		{name: "signal", paths: []string{"config/app.yaml"}, action: actionSignal, matched: []int{3}},
+		{name: "anchored", paths: []string{".env"}, action: actionRestart, matched: []int{4}},
+		{name: "anchored elsewhere", paths: []string{"sub/.env"}, action: actionRebuild},
+		{name: "escaped", paths: []string{"weird*.go"}, action: actionIgnore, matched: []int{6}},
+		{name: "escaped star is literal", paths: []string{"weirdo.go"}, action: actionRebuild},
+
+		// a batch takes the strongest action of its files
+		{name: "ignore and run", paths: []string{"README.md", "static/site.css"}, action: actionRun, matched: []int{2}},
+		{name: "runs collected in order", paths: []string{"static/site.css", "templates/page.html", "static/other.css"}, action: actionRun, matched: []int{2, 1}},
+		{name: "run and signal", paths: []string{"static/site.css", "config/app.yaml"}, action: actionSignal, matched: []int{3}},
+		{name: "signal and restart", paths: []string{"config/app.yaml", ".env"}, action: actionRestart, matched: []int{4}},
+		{name: "restart and rebuild", paths: []string{".env", "main.go"}, action: actionRebuild},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			for _, path := range tt.paths {
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(dir, path)
+				}
+				paths = append(paths, path)
+			}
+
+			plan := rules.plan(paths)
+			var matched []int
+			for _, rule := range plan.rules {
+				matched = append(matched, slices.Index(rules.rules, rule))
+			}
+			if plan.action != tt.action || !slices.Equal(matched, tt.matched) {
+				t.Errorf("plan(%q) = action %d, rules %v, want action %d, rules %v", tt.paths, plan.action, matched, tt.action, tt.matched)
+			}
+		})
+	}
+
+	if sig := rules.rules[3].signal; sig != syscall.SIGHUP {
+		t.Errorf("signal = %v, want %v", sig, syscall.SIGHUP)
+	}
+}
+
+func TestNewChangeRulesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rule config.ChangeRule
+		err  string
+	}{
+		{
+			name: "unknown action",
+			rule: config.ChangeRule{Paths: []string{"*.go"}, Action: "reboot"},
+			err:  `on_change rule 1: unknown action "reboot"`,
+		},
+		{
+			name: "unknown signal",
+			rule: config.ChangeRule{Paths: []string{"*.go"}, Action: config.ActionSignal, Signal: "SIGNOPE"},
+			err:  `on_change rule 1: unknown signal "SIGNOPE"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newChangeRules(&config.App{OnChange: []config.ChangeRule{tt.rule}})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
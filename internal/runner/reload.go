@@ -0,0 +1,199 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/mktcz/wisp/internal/config"
+	"github.com/mktcz/wisp/internal/watcher"
+)
+
+// SetConfigLoader enables reloading the configuration while running. When
+// any of the configuration files changes, load is called and the running
+// apps are reconciled with the result: new apps are started, removed ones
+// stopped, and apps whose settings changed are restarted. If load fails the
+// error is reported and the current configuration stays in effect.
+func (r *Runner) SetConfigLoader(load func() (*config.Config, error)) {
+	r.loadConfig = load
+}
+
+// configPaths returns the configuration files along with the local override
+// files that may be created next to them later.
+func configPaths(files []string) []string {
+	paths := make([]string, 0, len(files)*2)
+	for _, file := range files {
+		paths = append(paths, file)
+		if local := config.LocalOverridePath(file); local != "" {
+			paths = append(paths, local)
+		}
+	}
+	return paths
+}
+
+func (r *Runner) watchConfig() error {
+	files := configPaths(r.config.Files)
+
+	configWatcher, err := watcher.New(300 * time.Millisecond)
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+
+	if err := configWatcher.WatchFiles(files...); err != nil {
+		configWatcher.Stop()
+		return err
+	}
+
+	r.mu.Lock()
+	r.configWatcher = configWatcher
+	r.mu.Unlock()
+
+	configWatcher.Start()
+	go r.handleConfigChanges(configWatcher)
+
+	return nil
+}
+
+func (r *Runner) handleConfigChanges(configWatcher *watcher.Watcher) {
+	for {
+		select {
+		case batch := <-configWatcher.Events:
+			wd, _ := os.Getwd()
+			log.Printf("Reloading the configuration because %s changed", batch.Describe(wd))
+			r.reloadConfig()
+
+		case err := <-configWatcher.Errors:
+			log.Printf("Config watcher error: %v", err)
+
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *Runner) reloadConfig() {
+	cfg, err := r.loadConfig()
+	if err != nil {
+		log.Printf("Configuration not reloaded, keeping the previous one:\n%v", err)
+		return
+	}
+
+	wanted, err := r.selectApps(cfg, false)
+	if err != nil {
+		log.Printf("Configuration not reloaded, keeping the previous one: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	r.config = cfg
+	running := make(map[string]*config.App, len(r.apps))
+	for name, app := range r.apps {
+		running[name] = app
+	}
+	// the files may have changed with the configuration, and must not
+	// trigger rebuilds either
+	for _, w := range r.watchers {
+		w.IgnoreFiles(configPaths(cfg.Files)...)
+	}
+	r.mu.Unlock()
+
+	var removed, added, changed []string
+	for name := range running {
+		if _, ok := wanted[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	for name, app := range wanted {
+		current, ok := running[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case !reflect.DeepEqual(current, app):
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	sort.Strings(changed)
+
+	if len(removed)+len(added)+len(changed) == 0 {
+		log.Println("Configuration reloaded, no application settings changed")
+		return
+	}
+
+	for _, name := range removed {
+		log.Printf("[%s] Removed from configuration, stopping...", name)
+	}
+	for _, name := range changed {
+		log.Printf("[%s] Settings changed, restarting...", name)
+	}
+	for _, name := range added {
+		log.Printf("[%s] Added to configuration", name)
+	}
+
+	// stop dependents before the apps they depend on
+	stopping := make(map[string]*config.App)
+	for _, name := range append(append([]string{}, removed...), changed...) {
+		stopping[name] = running[name]
+	}
+	stages, err := config.StartOrder(stopping)
+	if err != nil {
+		stages = [][]string{append(append([]string{}, removed...), changed...)}
+	}
+	for i := len(stages) - 1; i >= 0; i-- {
+		for _, name := range stages[i] {
+			r.stopApp(name)
+		}
+	}
+
+	starting := make(map[string]*config.App)
+	for _, name := range append(append([]string{}, changed...), added...) {
+		starting[name] = wanted[name]
+	}
+	for _, err := range r.startApps(starting) {
+		log.Printf("Error: %v", err)
+	}
+
+	log.Printf("Configuration reloaded: %d added, %d removed, %d restarted", len(added), len(removed), len(changed))
+	r.logStatus()
+}
+
+// stopApp stops a single app along with its watchers, leaving the others
+// running.
+func (r *Runner) stopApp(name string) {
+	r.mu.Lock()
+	manager := r.managers[name]
+	fileWatcher := r.watchers[name]
+	envWatcher := r.envWatchers[name]
+	stop := r.stops[name]
+	delete(r.apps, name)
+	delete(r.managers, name)
+	delete(r.watchers, name)
+	delete(r.envWatchers, name)
+	delete(r.stops, name)
+	r.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+	}
+
+	for _, w := range []*watcher.Watcher{fileWatcher, envWatcher} {
+		if w == nil {
+			continue
+		}
+		if err := w.Stop(); err != nil {
+			log.Printf("[%s] Error stopping watcher: %v", name, err)
+		}
+	}
+
+	if manager != nil {
+		// a restart in progress would start the process again
+		manager.Close()
+		if err := manager.Stop(); err != nil {
+			log.Printf("[%s] Error stopping process: %v", name, err)
+		}
+	}
+}
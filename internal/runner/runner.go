@@ -1,57 +1,120 @@
 package runner
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/mktcz/wisp/internal/config"
+	"github.com/mktcz/wisp/internal/godeps"
+	"github.com/mktcz/wisp/internal/probe"
 	"github.com/mktcz/wisp/internal/process"
 	"github.com/mktcz/wisp/internal/session"
 	"github.com/mktcz/wisp/internal/watcher"
 )
 
 type Runner struct {
-	config     *config.Config
-	managers   map[string]*process.Manager
-	watchers   map[string]*watcher.Watcher
-	sessionDir string
-	mu         sync.RWMutex
-	done       chan struct{}
-	interrupt  chan os.Signal
+	config        *config.Config
+	apps          map[string]*config.App
+	managers      map[string]*process.Manager
+	watchers      map[string]*watcher.Watcher
+	envWatchers   map[string]*watcher.Watcher
+	stops         map[string]chan struct{}
+	appNames      []string
+	loadConfig    func() (*config.Config, error)
+	configWatcher *watcher.Watcher
+	sessionDir    string
+	mu            sync.RWMutex
+	done          chan struct{}
+	interrupt     chan os.Signal
 }
 
 func New(cfg *config.Config) *Runner {
 	return &Runner{
-		config:    cfg,
-		managers:  make(map[string]*process.Manager),
-		watchers:  make(map[string]*watcher.Watcher),
-		done:      make(chan struct{}),
-		interrupt: make(chan os.Signal, 1),
+		config:      cfg,
+		apps:        make(map[string]*config.App),
+		managers:    make(map[string]*process.Manager),
+		watchers:    make(map[string]*watcher.Watcher),
+		envWatchers: make(map[string]*watcher.Watcher),
+		stops:       make(map[string]chan struct{}),
+		done:        make(chan struct{}),
+		interrupt:   make(chan os.Signal, 1),
 	}
 }
 
 func (r *Runner) Run(appNames ...string) error {
-	// Generate session directory for this run
-	sessionDir, err := session.GenerateSessionDir()
-	if err != nil {
+	// Use the session directory the config was expanded with, or generate one
+	sessionDir := r.config.SessionDir
+	if sessionDir == "" {
+		var err error
+		if sessionDir, err = session.GenerateSessionDir(); err != nil {
+			return fmt.Errorf("failed to create session directory: %w", err)
+		}
+	} else if err := os.MkdirAll(sessionDir, 0755); err != nil {
 		return fmt.Errorf("failed to create session directory: %w", err)
 	}
 	r.sessionDir = sessionDir
 
-	appsToRun := make(map[string]*config.App)
+	r.appNames = appNames
+	appsToRun, err := r.selectApps(r.config, true)
+	if err != nil {
+		return err
+	}
 
-	if len(appNames) > 0 {
+	if len(appsToRun) == 0 {
+		return fmt.Errorf("no enabled applications configured")
+	}
+
+	signal.Notify(r.interrupt, os.Interrupt, syscall.SIGTERM)
 
-		for _, name := range appNames {
-			app, exists := r.config.Apps[name]
+	if errs := r.startApps(appsToRun); len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("Error: %v", err)
+		}
+		r.Shutdown()
+		return fmt.Errorf("one or more applications failed to start")
+	}
+
+	if r.loadConfig != nil {
+		if err := r.watchConfig(); err != nil {
+			log.Printf("Warning: configuration changes will not be reloaded: %v", err)
+		}
+	}
+
+	log.Printf("Wisp is running %d application(s). Press Ctrl+C to stop.", len(appsToRun))
+	r.logStatus()
+
+	select {
+	case <-r.interrupt:
+		log.Println("\nReceived interrupt signal, shutting down...")
+		r.Shutdown()
+	case <-r.done:
+		log.Println("Runner stopped")
+	}
+
+	return nil
+}
+
+// selectApps returns copies of the apps this runner should run, with their
+// paths translated: the apps named on the command line, which run even when
+// disabled, or else every enabled app. The apps they depend on are always
+// included.
+func (r *Runner) selectApps(cfg *config.Config, verbose bool) (map[string]*config.App, error) {
+	appsToRun := make(map[string]*config.App)
+
+	if len(r.appNames) > 0 {
+		for _, name := range r.appNames {
+			app, exists := cfg.Apps[name]
 			if !exists {
-				return fmt.Errorf("app '%s' not found in configuration", name)
+				return nil, fmt.Errorf("app '%s' not found in configuration", name)
 			}
 			// Create a copy of the app config with translated paths
 			appCopy := *app
@@ -59,76 +122,152 @@ func (r *Runner) Run(appNames ...string) error {
 			appsToRun[name] = &appCopy
 		}
 	} else {
-		// Copy all apps with translated paths
-		for name, app := range r.config.Apps {
+		// Copy all enabled apps with translated paths
+		for name, app := range cfg.Apps {
+			if !app.Enabled {
+				if verbose {
+					log.Printf("[%s] Disabled, skipping", name)
+				}
+				continue
+			}
 			appCopy := *app
 			r.translatePaths(&appCopy)
 			appsToRun[name] = &appCopy
 		}
 	}
 
-	if len(appsToRun) == 0 {
-		return fmt.Errorf("no applications configured")
+	selected := make([]string, 0, len(appsToRun))
+	for name := range appsToRun {
+		selected = append(selected, name)
 	}
+	sort.Strings(selected)
 
-	signal.Notify(r.interrupt, os.Interrupt, syscall.SIGTERM)
+	for _, name := range selected {
+		for _, dep := range cfg.Dependencies(name) {
+			if _, ok := appsToRun[dep]; ok {
+				continue
+			}
+			if verbose {
+				log.Printf("[%s] Required by %s, starting it as well", dep, name)
+			}
+			appCopy := *cfg.Apps[dep]
+			r.translatePaths(&appCopy)
+			appsToRun[dep] = &appCopy
+		}
+	}
 
-	var wg sync.WaitGroup
-	startErrors := make(chan error, len(appsToRun))
+	return appsToRun, nil
+}
+
+// startApps starts the given apps concurrently, holding each one back until
+// the apps it depends on are ready. An app whose dependency failed to start
+// or become ready is not started at all.
+func (r *Runner) startApps(apps map[string]*config.App) []error {
+	type startup struct {
+		done chan struct{}
+		err  error
+	}
 
-	for name, app := range appsToRun {
+	startups := make(map[string]*startup, len(apps))
+	for name := range apps {
+		startups[name] = &startup{done: make(chan struct{})}
+	}
+
+	var wg sync.WaitGroup
+	for name, app := range apps {
 		wg.Add(1)
 		go func(appName string, appConfig *config.App) {
 			defer wg.Done()
+			s := startups[appName]
+			defer close(s.done)
+
+			for _, dep := range appConfig.DependsOn {
+				depStartup, ok := startups[dep]
+				if !ok {
+					continue
+				}
+				<-depStartup.done
+				if depStartup.err != nil {
+					var notReady *process.NotReadyError
+					if errors.As(depStartup.err, &notReady) {
+						s.err = fmt.Errorf("[%s] not started, dependency '%s' did not become ready", appName, dep)
+					} else {
+						s.err = fmt.Errorf("[%s] not started, dependency '%s' failed to start", appName, dep)
+					}
+					return
+				}
+			}
+
 			if err := r.startApp(appName, appConfig); err != nil {
-				startErrors <- fmt.Errorf("[%s] %w", appName, err)
+				s.err = fmt.Errorf("[%s] %w", appName, err)
 			}
 		}(name, app)
 	}
-
 	wg.Wait()
-	close(startErrors)
-
-	var startupFailed bool
-	for err := range startErrors {
-		log.Printf("Error: %v", err)
-		startupFailed = true
-	}
 
-	if startupFailed {
-		r.Shutdown()
-		return fmt.Errorf("one or more applications failed to start")
+	names := make([]string, 0, len(startups))
+	for name := range startups {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	log.Printf("Wisp is running %d application(s). Press Ctrl+C to stop.", len(appsToRun))
-
-	select {
-	case <-r.interrupt:
-		log.Println("\nReceived interrupt signal, shutting down...")
-		r.Shutdown()
-	case <-r.done:
-		log.Println("Runner stopped")
+	var errs []error
+	for _, name := range names {
+		if err := startups[name].err; err != nil {
+			errs = append(errs, err)
+		}
 	}
-
-	return nil
+	return errs
 }
 
 func (r *Runner) startApp(name string, app *config.App) error {
 	log.Printf("[%s] Starting application...", name)
 
 	manager := process.NewManager(app)
+	stop := make(chan struct{})
+
+	manager.SetDelays(
+		time.Duration(app.StopDelay)*time.Millisecond,
+		time.Duration(app.StartDelay)*time.Millisecond,
+	)
+	manager.SetBuildDir(r.sessionDir)
+
+	if app.Ready != nil {
+		readiness, err := probe.New(app.Ready)
+		if err != nil {
+			return fmt.Errorf("invalid ready check: %w", err)
+		}
+		manager.SetReadiness(readiness)
+	}
 
-	manager.SetDelays(1*time.Second, 500*time.Millisecond)
+	if app.Liveness != nil {
+		liveness, err := probe.NewLiveness(app.Liveness, app.Shell, app.WorkingDir)
+		if err != nil {
+			return fmt.Errorf("invalid liveness check: %w", err)
+		}
+		manager.SetLiveness(liveness, app.Liveness.DumpDir)
+	}
+
+	rules, err := newChangeRules(app)
+	if err != nil {
+		return err
+	}
 
 	r.mu.Lock()
+	r.apps[name] = app
 	r.managers[name] = manager
+	r.stops[name] = stop
 	r.mu.Unlock()
 
 	if err := manager.Restart(); err != nil {
+		var notReady *process.NotReadyError
+		if errors.As(err, &notReady) {
+			return err
+		}
 		return fmt.Errorf("failed to start: %w", err)
 	}
 
-	fileWatcher, err := watcher.New(300 * time.Millisecond)
+	fileWatcher, err := watcher.New(time.Duration(app.Debounce) * time.Millisecond)
 	if err != nil {
 		return fmt.Errorf("failed to create watcher: %w", err)
 	}
@@ -136,44 +275,207 @@ func (r *Runner) startApp(name string, app *config.App) error {
 	if err := fileWatcher.SetExcludes(app.ExcludeDir, app.ExcludeFile, app.ExcludeRegex); err != nil {
 		return fmt.Errorf("failed to set excludes: %w", err)
 	}
+	fileWatcher.SetIncludes(app.IncludeExt, app.IncludeFile)
 	fileWatcher.SetFollowSymlink(app.FollowSymlink)
+	fileWatcher.SetUseGitignore(app.UseGitignore)
+	setWatchMode(fileWatcher, app)
+	// env files only need a restart, which the env watcher below takes care of
+	fileWatcher.IgnoreFiles(app.EnvFile...)
+	// the binary is rewritten, or at least touched, by every build; go build
+	// also probes the umask with a temporary file next to it
+	if binary := manager.BinaryPath(); binary != "" {
+		fileWatcher.IgnoreFiles(binary, binary+"-go-tmp-umask")
+	}
+	if r.loadConfig != nil {
+		// configuration changes are applied by reloading instead
+		fileWatcher.IgnoreFiles(configPaths(r.config.Files)...)
+	}
 
 	r.mu.Lock()
 	r.watchers[name] = fileWatcher
 	r.mu.Unlock()
 
-	if err := fileWatcher.Watch(app.WatchDir); err != nil {
-		return fmt.Errorf("failed to watch directory %s: %w", app.WatchDir, err)
+	var graph *godeps.Graph
+	if app.WatchGoDeps {
+		if graph, err = watchGoDeps(name, app, fileWatcher); err != nil {
+			log.Printf("[%s] Warning: cannot watch Go dependencies, watching watch_dir instead: %v", name, err)
+		}
+	}
+
+	if graph == nil {
+		for _, watchDir := range app.WatchDir {
+			if err := fileWatcher.Watch(watchDir); err != nil {
+				return fmt.Errorf("failed to watch directory %s: %w", watchDir, err)
+			}
+		}
+		if len(app.WatchDir) == 1 {
+			log.Printf("[%s] Watching directory: %s", name, app.WatchDir[0])
+		} else {
+			log.Printf("[%s] Watching directories: %s", name, strings.Join(app.WatchDir, ", "))
+		}
 	}
 
 	fileWatcher.Start()
-	log.Printf("[%s] Watching directory: %s", name, app.WatchDir)
 
-	go r.handleFileChanges(name, manager, fileWatcher)
+	go r.handleFileChanges(name, manager, fileWatcher, graph, rules, stop)
+
+	if len(app.EnvFile) > 0 {
+		envWatcher, err := watcher.New(time.Duration(app.Debounce) * time.Millisecond)
+		if err != nil {
+			return fmt.Errorf("failed to create env file watcher: %w", err)
+		}
+
+		setWatchMode(envWatcher, app)
+
+		r.mu.Lock()
+		r.envWatchers[name] = envWatcher
+		r.mu.Unlock()
+
+		if err := envWatcher.WatchFiles(app.EnvFile...); err != nil {
+			return fmt.Errorf("failed to watch env files: %w", err)
+		}
+
+		envWatcher.Start()
+		log.Printf("[%s] Watching env files: %s", name, strings.Join(app.EnvFile, ", "))
+
+		go r.handleEnvChanges(name, app.WorkingDir, manager, envWatcher, stop)
+	}
 
 	return nil
 }
 
-func (r *Runner) handleFileChanges(appName string, manager *process.Manager, fileWatcher *watcher.Watcher) {
+func (r *Runner) handleFileChanges(appName string, manager *process.Manager, fileWatcher *watcher.Watcher, graph *godeps.Graph, rules *changeRules, stop <-chan struct{}) {
 	for {
 		select {
-		case <-fileWatcher.Events:
-			log.Printf("[%s] File change detected, rebuilding...", appName)
-
-			if err := manager.Restart(); err != nil {
-				log.Printf("[%s] Restart failed: %v", appName, err)
+		case batch := <-fileWatcher.Events:
+			if graph != nil && graph.Stale() {
+				if err := refreshGoDeps(appName, graph, fileWatcher); err != nil {
+					log.Printf("[%s] Warning: %v", appName, err)
+				}
+			}
 
+			plan := rules.plan(batch.Paths())
+			changed := batch.Describe(rules.dir)
+			switch plan.action {
+			case actionIgnore:
+				continue
+			case actionRun:
+				log.Printf("[%s] Running on_change commands because %s changed", appName, changed)
+			case actionSignal:
+				log.Printf("[%s] Signalling because %s changed", appName, changed)
+			case actionRestart:
+				log.Printf("[%s] Restarting because %s changed", appName, changed)
+			case actionRebuild:
+				log.Printf("[%s] Rebuilding because %s changed", appName, changed)
 			}
 
+			// keep listening while building, so that newer changes can
+			// cancel a build that is already stale
+			go r.applyChanges(appName, manager, plan)
+
 		case err := <-fileWatcher.Errors:
 			log.Printf("[%s] Watcher error: %v", appName, err)
 
+		case <-stop:
+			return
+
 		case <-r.done:
 			return
 		}
 	}
 }
 
+func (r *Runner) rebuild(appName string, manager *process.Manager) {
+	if err := manager.Restart(); err != nil {
+		if !errors.Is(err, process.ErrSuperseded) && !errors.Is(err, process.ErrUnchanged) {
+			log.Printf("[%s] Restart failed: %v", appName, err)
+		}
+		return
+	}
+	r.restartDependents(appName)
+}
+
+func (r *Runner) handleEnvChanges(appName, dir string, manager *process.Manager, envWatcher *watcher.Watcher, stop <-chan struct{}) {
+	for {
+		select {
+		case batch := <-envWatcher.Events:
+			log.Printf("[%s] Restarting because %s changed", appName, batch.Describe(dir))
+
+			if err := manager.RestartWithoutBuild(); err != nil {
+				if !errors.Is(err, process.ErrSuperseded) {
+					log.Printf("[%s] Restart failed: %v", appName, err)
+				}
+				continue
+			}
+			r.restartDependents(appName)
+
+		case err := <-envWatcher.Errors:
+			log.Printf("[%s] Env watcher error: %v", appName, err)
+
+		case <-stop:
+			return
+
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// setWatchMode applies the app's polling settings, which are also used if
+// the watcher falls back to polling, and switches to polling if asked to.
+// Unchanged files are ignored, and git operations waited for, in either mode.
+func setWatchMode(w *watcher.Watcher, app *config.App) {
+	w.SetIgnoreUnchanged(app.IgnoreUnchanged)
+	w.SetWaitForGit(app.WaitForGit)
+	w.SetPollOptions(time.Duration(app.PollInterval)*time.Millisecond, app.PollHash)
+	if app.WatchMode == config.WatchModePoll {
+		w.UsePolling()
+	}
+}
+
+// restartDependents restarts, without rebuilding, the running apps that set
+// restart_with_dependencies and depend on name, either directly or through
+// other apps restarted this way. Each app is restarted once, after the apps
+// it depends on.
+func (r *Runner) restartDependents(name string) {
+	r.mu.RLock()
+	affected := make(map[string]*config.App)
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for dependent, app := range r.apps {
+			if _, seen := affected[dependent]; seen || !app.RestartWithDependencies {
+				continue
+			}
+			if slices.Contains(app.DependsOn, current) {
+				affected[dependent] = app
+				queue = append(queue, dependent)
+			}
+		}
+	}
+	managers := make(map[string]*process.Manager, len(affected))
+	for dependent := range affected {
+		managers[dependent] = r.managers[dependent]
+	}
+	r.mu.RUnlock()
+
+	stages, err := config.StartOrder(affected)
+	if err != nil {
+		log.Printf("[%s] Not restarting dependents: %v", name, err)
+		return
+	}
+
+	for _, stage := range stages {
+		for _, dependent := range stage {
+			log.Printf("[%s] Restarting because %s was restarted...", dependent, name)
+			if err := managers[dependent].RestartWithoutBuild(); err != nil && !errors.Is(err, process.ErrSuperseded) {
+				log.Printf("[%s] Restart failed: %v", dependent, err)
+			}
+		}
+	}
+}
+
 func (r *Runner) RunSingle(appName string) error {
 	return r.Run(appName)
 }
@@ -188,6 +490,12 @@ func (r *Runner) Shutdown() {
 	for _, w := range r.watchers {
 		watchers = append(watchers, w)
 	}
+	for _, w := range r.envWatchers {
+		watchers = append(watchers, w)
+	}
+	if r.configWatcher != nil {
+		watchers = append(watchers, r.configWatcher)
+	}
 	r.mu.RUnlock()
 
 	for _, w := range watchers {
@@ -197,29 +505,52 @@ func (r *Runner) Shutdown() {
 	}
 
 	r.mu.RLock()
-	managers := make([]*process.Manager, 0, len(r.managers))
-	names := make([]string, 0, len(r.managers))
+	managers := make(map[string]*process.Manager, len(r.managers))
+	apps := make(map[string]*config.App, len(r.apps))
 	for name, m := range r.managers {
-		managers = append(managers, m)
-		names = append(names, name)
+		managers[name] = m
+		apps[name] = r.apps[name]
 	}
 	r.mu.RUnlock()
 
-	var wg sync.WaitGroup
-	for i, m := range managers {
-		wg.Add(1)
-		go func(manager *process.Manager, name string) {
-			defer wg.Done()
-			if err := manager.Stop(); err != nil {
-				log.Printf("[%s] Error stopping process: %v", name, err)
-			}
-		}(m, names[i])
+	// stop dependents before the apps they depend on
+	stages, err := config.StartOrder(apps)
+	if err != nil {
+		stages = [][]string{make([]string, 0, len(managers))}
+		for name := range managers {
+			stages[0] = append(stages[0], name)
+		}
 	}
 
 	done := make(chan struct{})
 	go func() {
-		wg.Wait()
-		close(done)
+		defer close(done)
+
+		// cancel the restarts in progress first, so that none of them starts
+		// a process once it has been stopped
+		var closing sync.WaitGroup
+		for _, manager := range managers {
+			closing.Add(1)
+			go func(manager *process.Manager) {
+				defer closing.Done()
+				manager.Close()
+			}(manager)
+		}
+		closing.Wait()
+
+		for i := len(stages) - 1; i >= 0; i-- {
+			var wg sync.WaitGroup
+			for _, name := range stages[i] {
+				wg.Add(1)
+				go func(manager *process.Manager, name string) {
+					defer wg.Done()
+					if err := manager.Stop(); err != nil {
+						log.Printf("[%s] Error stopping process: %v", name, err)
+					}
+				}(managers[name], name)
+			}
+			wg.Wait()
+		}
 	}()
 
 	select {
@@ -238,19 +569,19 @@ func (r *Runner) Shutdown() {
 	r.cleanupSessionDirectories()
 }
 
-// translatePaths converts relative ./tmp paths to session directory paths
+// translatePaths converts relative ./tmp paths to session directory paths.
+// This predates ${session_dir} and is kept for existing configurations.
 func (r *Runner) translatePaths(app *config.App) {
 	if r.sessionDir == "" {
 		return
 	}
 	
 	// Translate build command paths
-	if strings.Contains(app.Cmd, "./tmp/") {
-		app.Cmd = strings.ReplaceAll(app.Cmd, "./tmp/", r.sessionDir+"/")
-	}
-	if strings.Contains(app.BuildCmd, "./tmp/") {
-		app.BuildCmd = strings.ReplaceAll(app.BuildCmd, "./tmp/", r.sessionDir+"/")
+	toSessionDir := func(s string) string {
+		return strings.ReplaceAll(s, "./tmp/", r.sessionDir+"/")
 	}
+	app.Cmd = app.Cmd.Map(toSessionDir)
+	app.BuildCmd = app.BuildCmd.Map(toSessionDir)
 	
 	// Translate binary path
 	if strings.HasPrefix(app.Bin, "./tmp/") {
@@ -274,6 +605,45 @@ func (r *Runner) cleanupSessionDirectories() {
 	}
 }
 
+// AppStatus is the state of a single app for display.
+type AppStatus struct {
+	Name string
+	// Status is "stopped", "starting" until the readiness check passes, or
+	// "ready".
+	Status string
+	// ReadinessCheck describes the readiness check, if the app has one.
+	ReadinessCheck string
+}
+
+// Status returns the state of every app the runner manages, sorted by name.
+func (r *Runner) Status() []AppStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	statuses := make([]AppStatus, 0, len(r.managers))
+	for name, manager := range r.managers {
+		statuses = append(statuses, AppStatus{
+			Name:           name,
+			Status:         manager.Status(),
+			ReadinessCheck: manager.ReadinessCheck(),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+func (r *Runner) logStatus() {
+	for _, status := range r.Status() {
+		if status.ReadinessCheck != "" {
+			log.Printf("[%s] %s (%s)", status.Name, status.Status, status.ReadinessCheck)
+		} else {
+			log.Printf("[%s] %s", status.Name, status.Status)
+		}
+	}
+}
+
 func (r *Runner) GetRunningApps() []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -9,15 +9,11 @@ import (
 
 // GenerateSessionDir creates a unique session directory under /tmp/wisp/
 func GenerateSessionDir() (string, error) {
-	// Generate a random UUID-like string
-	sessionID, err := generateSessionID()
+	sessionDir, err := NewSessionPath()
 	if err != nil {
-		return "", fmt.Errorf("failed to generate session ID: %w", err)
+		return "", err
 	}
 	
-	// Create the wisp session directory
-	sessionDir := filepath.Join("/tmp", "wisp", sessionID)
-	
 	// Create the directory with proper permissions
 	if err := os.MkdirAll(sessionDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create session directory: %w", err)
@@ -26,6 +22,18 @@ func GenerateSessionDir() (string, error) {
 	return sessionDir, nil
 }
 
+// NewSessionPath picks a unique session directory path under /tmp/wisp/
+// without creating it, so it can be referenced before the session starts
+func NewSessionPath() (string, error) {
+	// Generate a random UUID-like string
+	sessionID, err := generateSessionID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	
+	return filepath.Join("/tmp", "wisp", sessionID), nil
+}
+
 // CleanupSessionDir removes the session directory and all its contents
 func CleanupSessionDir(sessionDir string) error {
 	if sessionDir == "" {
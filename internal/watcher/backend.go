@@ -0,0 +1,73 @@
+package watcher
+
+import (
+	"fmt"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// backend reports changes to the entries of the directories added to it,
+// without their subdirectories, on the channels it was created with.
+type backend interface {
+	Add(dir string) error
+	Remove(dir string) error
+	Close() error
+}
+
+// notifyBackend relies on the operating system's notifications: inotify,
+// kqueue or ReadDirectoryChangesW.
+type notifyBackend struct {
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+func newNotifyBackend(events chan<- fsnotify.Event, errors chan<- error) (*notifyBackend, error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
+
+	b := &notifyBackend{watcher: fsWatcher, done: make(chan struct{})}
+	go b.forward(events, errors)
+	return b, nil
+}
+
+func (b *notifyBackend) forward(events chan<- fsnotify.Event, errors chan<- error) {
+	for {
+		select {
+		case event, ok := <-b.watcher.Events:
+			if !ok {
+				return
+			}
+			select {
+			case events <- event:
+			case <-b.done:
+				return
+			}
+		case err, ok := <-b.watcher.Errors:
+			if !ok {
+				return
+			}
+			select {
+			case errors <- err:
+			case <-b.done:
+				return
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *notifyBackend) Add(dir string) error {
+	return b.watcher.Add(dir)
+}
+
+func (b *notifyBackend) Remove(dir string) error {
+	return b.watcher.Remove(dir)
+}
+
+func (b *notifyBackend) Close() error {
+	close(b.done)
+	return b.watcher.Close()
+}
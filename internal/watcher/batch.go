@@ -0,0 +1,94 @@
+package watcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reportedOps are the operations a batch records; permission changes alone
+// are never reported.
+const reportedOps = fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename
+
+// Change is a changed path along with every operation seen on it during the
+// debounce period, such as fsnotify.Create|fsnotify.Write for a new file.
+type Change struct {
+	Path string
+	Op   fsnotify.Op
+	// created is set when the first change created the path, which did
+	// not exist before the batch.
+	created bool
+}
+
+// Batch holds the changes made during a debounce period, one per path, in
+// the order the paths were first changed.
+type Batch struct {
+	Changes []Change
+}
+
+// Paths returns the changed paths.
+func (b Batch) Paths() []string {
+	paths := make([]string, len(b.Changes))
+	for i, change := range b.Changes {
+		paths[i] = change.Path
+	}
+	return paths
+}
+
+// Describe names the first changed path, relative to dir when it is inside
+// it, and counts the others, as in "internal/db/query.go, 2 others".
+func (b Batch) Describe(dir string) string {
+	if len(b.Changes) == 0 {
+		return "nothing"
+	}
+
+	name := b.Changes[0].Path
+	if rel, err := filepath.Rel(dir, name); err == nil && !strings.HasPrefix(rel, "..") {
+		name = rel
+	}
+
+	switch others := len(b.Changes) - 1; others {
+	case 0:
+		return name
+	case 1:
+		return name + ", 1 other"
+	default:
+		return fmt.Sprintf("%s, %d others", name, others)
+	}
+}
+
+// batcher collects the changes of a debounce period. It is safe for
+// concurrent use.
+type batcher struct {
+	mu      sync.Mutex
+	changes []Change
+	index   map[string]int
+}
+
+func (c *batcher) add(path string, op fsnotify.Op) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.index == nil {
+		c.index = make(map[string]int)
+	}
+	if i, ok := c.index[path]; ok {
+		c.changes[i].Op |= op & reportedOps
+		return
+	}
+	c.index[path] = len(c.changes)
+	c.changes = append(c.changes, Change{Path: path, Op: op & reportedOps, created: op&fsnotify.Create != 0})
+}
+
+// take returns the changes collected so far and starts over.
+func (c *batcher) take() Batch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	batch := Batch{Changes: c.changes}
+	c.changes, c.index = nil, nil
+	return batch
+}
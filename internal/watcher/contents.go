@@ -0,0 +1,262 @@
+package watcher
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"os"
+	"sync"
+)
+
+const (
+	// maxIndexedFiles bounds the number of files remembered by each watcher,
+	// and the number of sums shared between them. The entries used least
+	// recently are forgotten first.
+	maxIndexedFiles = 20000
+	// maxHashedSize is the size above which files are not hashed, and
+	// their changes always reported.
+	maxHashedSize = 16 << 20
+)
+
+// fileStat is what tells, without reading a file, that it was left alone.
+type fileStat struct {
+	size    int64
+	modTime int64
+}
+
+func statOf(info os.FileInfo) fileStat {
+	return fileStat{size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+// hashable reports whether the contents of a file are compared at all.
+func hashable(info os.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Size() <= maxHashedSize
+}
+
+// contentIndex remembers the watched files as they were last seen, to tell
+// the changes that leave a file as it was, such as an editor saving an
+// unmodified buffer, from real ones. Only sizes and modification times are
+// recorded when the files are watched; their contents are hashed in the
+// background afterwards, into sums shared by every watcher, so that watching
+// a large tree does not wait for it to be read. It is safe for concurrent
+// use.
+type contentIndex struct {
+	files *lru[string, fileStat]
+
+	mu sync.Mutex
+	// queued holds the files recorded since the baselines were last hashed.
+	queued []string
+	// wake tells hashBaselines that files were queued.
+	wake chan struct{}
+}
+
+func newContentIndex() *contentIndex {
+	return &contentIndex{
+		files: newLRU[string, fileStat](maxIndexedFiles),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// record remembers a file as it was when it was watched, unless the index is
+// already full, and queues it to be hashed.
+func (c *contentIndex) record(path string, info os.FileInfo) {
+	if !hashable(info) || !c.files.add(path, statOf(info)) {
+		return
+	}
+
+	c.mu.Lock()
+	c.queued = append(c.queued, path)
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// hashBaselines hashes the files queued by record as they are queued, until
+// done is closed.
+func (c *contentIndex) hashBaselines(done <-chan struct{}) {
+	for {
+		select {
+		case <-c.wake:
+			c.hashQueued(done)
+		case <-done:
+			return
+		}
+	}
+}
+
+// hashQueued hashes the files queued by record that are still as they were
+// recorded, so that their first change can be compared to their contents.
+func (c *contentIndex) hashQueued(done <-chan struct{}) {
+	c.mu.Lock()
+	queued := c.queued
+	c.queued = nil
+	c.mu.Unlock()
+
+	for _, path := range queued {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if stat, ok := c.files.peek(path); ok {
+			sums.hash(path, stat)
+		}
+	}
+}
+
+// changed reports whether the contents of the file at path differ from
+// when it was last seen, and remembers them. Files never seen before, gone,
+// too large to hash, or whose earlier contents are not known are reported as
+// changed.
+func (c *contentIndex) changed(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || !hashable(info) {
+		c.files.remove(path)
+		return true
+	}
+	current := statOf(info)
+
+	previous, known := c.files.get(path)
+	if known && previous == current {
+		return false
+	}
+	c.files.put(path, current)
+
+	sum, ok := sums.hash(path, current)
+	if !known || !ok {
+		return true
+	}
+	before, ok := sums.get(sumKey{path, previous})
+	return !ok || before != sum
+}
+
+// sumKey identifies the contents of a file by its path, size and
+// modification time.
+type sumKey struct {
+	path string
+	stat fileStat
+}
+
+// sums holds the SHA-256 sums of the files hashed by any watcher, so that
+// apps watching the same files only read them once.
+var sums = &sumCache{entries: newLRU[sumKey, [sha256.Size]byte](maxIndexedFiles)}
+
+type sumCache struct {
+	entries *lru[sumKey, [sha256.Size]byte]
+}
+
+func (c *sumCache) get(key sumKey) ([sha256.Size]byte, bool) {
+	return c.entries.get(key)
+}
+
+// hash returns the sum of the file at path as of stat, reading it unless it
+// is known already. It reports false if the file could not be read, or no
+// longer matches stat.
+func (c *sumCache) hash(path string, stat fileStat) ([sha256.Size]byte, bool) {
+	key := sumKey{path, stat}
+	if sum, ok := c.entries.get(key); ok {
+		return sum, true
+	}
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return sum, false
+	}
+	// the file may have been written while it was read
+	if info, err := os.Stat(path); err != nil || statOf(info) != stat {
+		return sum, false
+	}
+	c.entries.put(key, sum)
+	return sum, true
+}
+
+// lru is a map bounded to max entries, which forgets the entries used least
+// recently first. It is safe for concurrent use.
+type lru[K comparable, V any] struct {
+	mu      sync.Mutex
+	max     int
+	entries map[K]*list.Element
+	// recent holds the *lruEntry values, the most recently used first.
+	recent *list.List
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func newLRU[K comparable, V any](max int) *lru[K, V] {
+	return &lru[K, V]{
+		max:     max,
+		entries: make(map[K]*list.Element),
+		recent:  list.New(),
+	}
+}
+
+func (l *lru[K, V]) get(key K) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		l.recent.MoveToFront(element)
+		return element.Value.(*lruEntry[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// put stores value, forgetting the least recently used entry if the map is
+// full.
+func (l *lru[K, V]) put(key K, value V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		element.Value.(*lruEntry[K, V]).value = value
+		l.recent.MoveToFront(element)
+		return
+	}
+	if len(l.entries) >= l.max {
+		oldest := l.recent.Back()
+		l.recent.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruEntry[K, V]).key)
+	}
+	l.entries[key] = l.recent.PushFront(&lruEntry[K, V]{key: key, value: value})
+}
+
+// add stores value unless the key is known or the map is full, and reports
+// whether it did.
+func (l *lru[K, V]) add(key K, value V) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.entries[key]; ok || len(l.entries) >= l.max {
+		return false
+	}
+	l.entries[key] = l.recent.PushBack(&lruEntry[K, V]{key: key, value: value})
+	return true
+}
+
+// peek returns the value of key without counting as a use.
+func (l *lru[K, V]) peek(key K) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		return element.Value.(*lruEntry[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (l *lru[K, V]) remove(key K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		l.recent.Remove(element)
+		delete(l.entries, key)
+	}
+}
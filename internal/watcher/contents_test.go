@@ -0,0 +1,166 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// save writes contents to path, as of the given number of seconds after
+// the tests began, so that each save has its own modification time.
+func save(t *testing.T, path, contents string, second int) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Unix(1_700_000_000+int64(second), 0)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// watchedIndex records the file at path as a watcher would, and hashes it.
+func watchedIndex(t *testing.T, path string) *contentIndex {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newContentIndex()
+	c.record(path, info)
+	c.hashQueued(nil)
+	return c
+}
+
+func TestContentIndexChanged(t *testing.T) {
+	tests := []struct {
+		name string
+		// saves are written one after the other after the file is watched
+		saves []string
+		want  []bool
+	}{
+		{
+			name:  "unmodified save",
+			saves: []string{"package main"},
+			want:  []bool{false},
+		},
+		{
+			name:  "modified",
+			saves: []string{"package app"},
+			want:  []bool{true},
+		},
+		{
+			name:  "checkout away and back",
+			saves: []string{"package app", "package main"},
+			want:  []bool{true, true},
+		},
+		{
+			name:  "modified then saved again",
+			saves: []string{"package app", "package app"},
+			want:  []bool{true, false},
+		},
+		{
+			name:  "emptied",
+			saves: []string{""},
+			want:  []bool{true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "main.go")
+			save(t, path, "package main", 0)
+			c := watchedIndex(t, path)
+
+			for i, contents := range tt.saves {
+				save(t, path, contents, i+1)
+				if got := c.changed(path); got != tt.want[i] {
+					t.Errorf("save %d of %q: changed = %v, want %v", i+1, contents, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestContentIndexCheckoutBackInOneBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.go")
+	save(t, path, "package main", 0)
+	c := watchedIndex(t, path)
+
+	// both checkouts happen within the debounce period
+	save(t, path, "package app", 1)
+	save(t, path, "package main", 2)
+	if c.changed(path) {
+		t.Error("a file checked out away and back was reported as changed")
+	}
+}
+
+func TestContentIndexTouch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.go")
+	save(t, path, "package main", 0)
+	c := watchedIndex(t, path)
+
+	modTime := time.Now()
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if c.changed(path) {
+		t.Error("a touched file was reported as changed")
+	}
+}
+
+func TestContentIndexUnknownFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	created := filepath.Join(dir, "new.go")
+	save(t, created, "package main", 0)
+	c := newContentIndex()
+	if !c.changed(created) {
+		t.Error("a file never seen before was reported as unchanged")
+	}
+
+	if !c.changed(filepath.Join(dir, "missing.go")) {
+		t.Error("a removed file was reported as unchanged")
+	}
+}
+
+func TestContentIndexChangedBeforeHashed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.go")
+	save(t, path, "package main", 0)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newContentIndex()
+	c.record(path, info)
+
+	// the contents before the save are unknown
+	save(t, path, "package main", 1)
+	c.hashQueued(nil)
+	if !c.changed(path) {
+		t.Error("a file saved before it was hashed was reported as unchanged")
+	}
+}
+
+func TestLRUBound(t *testing.T) {
+	l := newLRU[string, int](2)
+	l.put("a", 1)
+	l.put("b", 2)
+	l.get("a")
+	l.put("c", 3)
+
+	if _, ok := l.get("b"); ok {
+		t.Error("the least recently used entry was kept")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := l.get(key); !ok {
+			t.Errorf("%s was forgotten", key)
+		}
+	}
+	if l.add("d", 4) {
+		t.Error("add stored an entry in a full map")
+	}
+}
@@ -0,0 +1,151 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// gitPollInterval is how often the repositories are checked while a git
+	// operation is in progress.
+	gitPollInterval = 200 * time.Millisecond
+	// gitMaxHold bounds how long changes are held for the same index lock,
+	// which a git process that crashed may have left behind.
+	gitMaxHold = 30 * time.Second
+)
+
+// gitLock is the file git holds in its directory while a command such as
+// checkout, reset or a step of a rebase writes the working tree.
+const gitLock = "index.lock"
+
+// gitSequencerMarkers are the files git keeps in its directory for the
+// whole of a multi-step operation, including while it is stopped on
+// conflicts, when the lock comes and goes between the steps.
+var gitSequencerMarkers = []string{
+	"rebase-merge",
+	"rebase-apply", // also git am
+	"MERGE_HEAD",
+	"CHERRY_PICK_HEAD",
+	"REVERT_HEAD",
+	"sequencer", // a series of cherry-picks or reverts
+}
+
+// gitRepos holds the git directories of the watched repositories, to tell
+// when a git operation is in progress in one of them. It is safe for
+// concurrent use.
+type gitRepos struct {
+	mu   sync.Mutex
+	dirs []string
+	// maxHold is how long changes are held for an index lock before it is
+	// taken to be stale.
+	maxHold time.Duration
+	// stale holds the modification times of the index locks held on for
+	// longer than maxHold, which are ignored until they are replaced.
+	stale map[string]time.Time
+
+	// newTicker and after make the timers of a hold.
+	newTicker func(d time.Duration) (<-chan time.Time, func())
+	after     func(d time.Duration) <-chan time.Time
+}
+
+func newGitRepos() *gitRepos {
+	return &gitRepos{
+		maxHold: gitMaxHold,
+		stale:   make(map[string]time.Time),
+		newTicker: func(d time.Duration) (<-chan time.Time, func()) {
+			ticker := time.NewTicker(d)
+			return ticker.C, ticker.Stop
+		},
+		after: time.After,
+	}
+}
+
+// add finds the repository dir is in, if any.
+func (g *gitRepos) add(dir string) {
+	gitDir := gitDirOf(repositoryRoot(dir))
+	if gitDir == "" {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !slices.Contains(g.dirs, gitDir) {
+		g.dirs = append(g.dirs, gitDir)
+	}
+}
+
+// busy returns the marker of the git operation in progress in one of the
+// repositories, or "" if there is none. An index lock taken to be stale
+// does not count.
+func (g *gitRepos) busy() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, dir := range g.dirs {
+		lock := filepath.Join(dir, gitLock)
+		if info, err := os.Lstat(lock); err != nil {
+			delete(g.stale, lock)
+		} else if modTime, ok := g.stale[lock]; !ok || !modTime.Equal(info.ModTime()) {
+			return lock
+		}
+
+		for _, marker := range gitSequencerMarkers {
+			path := filepath.Join(dir, marker)
+			if _, err := os.Lstat(path); err == nil {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// isLock reports whether marker, as returned by busy, is an index lock,
+// which changes are only held for up to maxHold.
+func isLock(marker string) bool {
+	return filepath.Base(marker) == gitLock
+}
+
+// markStale stops changes from being held for the index lock at path, until
+// it is replaced by a new one.
+func (g *gitRepos) markStale(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stale[path] = info.ModTime()
+}
+
+// gitDirOf returns the git directory of the working tree at root: root/.git,
+// or the directory a .git file points to in worktrees and submodules. It
+// returns "" if root is not a working tree.
+func gitDirOf(root string) string {
+	path := filepath.Join(root, ".git")
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if info.IsDir() {
+		return path
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	dir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return ""
+	}
+	dir = strings.TrimSpace(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return dir
+}
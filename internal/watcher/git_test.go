@@ -0,0 +1,242 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeGitTimers stands in for the timers of a hold, which the tests fire
+// themselves.
+type fakeGitTimers struct {
+	// holds receives a value each time changes start being held.
+	holds chan struct{}
+	// timeouts receives the duration of each hold timeout armed.
+	timeouts chan time.Duration
+	ticks    chan time.Time
+	timeout  chan time.Time
+}
+
+// newGitWatcher watches a new repository whose git directory holds the
+// given entries, and returns the watcher, not yet started, the working tree
+// and the timers of its holds.
+func newGitWatcher(t *testing.T, entries ...string) (*Watcher, string, *fakeGitTimers) {
+	t.Helper()
+
+	root := t.TempDir()
+	for _, entry := range append([]string{".git"}, entries...) {
+		if err := os.MkdirAll(filepath.Join(root, entry), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := New(10 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Stop() })
+
+	timers := &fakeGitTimers{
+		holds:    make(chan struct{}, 10),
+		timeouts: make(chan time.Duration, 10),
+		ticks:    make(chan time.Time),
+		timeout:  make(chan time.Time),
+	}
+	w.SetWaitForGit(true)
+	w.git.newTicker = func(time.Duration) (<-chan time.Time, func()) {
+		timers.holds <- struct{}{}
+		return timers.ticks, func() {}
+	}
+	w.git.after = func(d time.Duration) <-chan time.Time {
+		timers.timeouts <- d
+		return timers.timeout
+	}
+
+	if err := w.Watch(root); err != nil {
+		t.Fatal(err)
+	}
+	return w, root, timers
+}
+
+// receive waits for a value from ch, failing the test if none arrives.
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+// tick makes the watcher check the repositories again, failing the test if
+// it is not holding changes. Once a tick has been received the previous one
+// has been handled, so two ticks in a row show that the changes were still
+// held after the first.
+func (f *fakeGitTimers) tick(t *testing.T) {
+	t.Helper()
+
+	select {
+	case f.ticks <- time.Now():
+	case <-time.After(5 * time.Second):
+		t.Fatal("changes are no longer held")
+	}
+}
+
+// released ticks until the watcher reports the changes it held, and returns
+// them.
+func (f *fakeGitTimers) released(t *testing.T, w *Watcher) Batch {
+	t.Helper()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case f.ticks <- time.Now():
+		case batch := <-w.Events:
+			return batch
+		case <-deadline:
+			t.Fatal("the changes held were not reported")
+			return Batch{}
+		}
+	}
+}
+
+// expectNone fails the test if ch holds a value.
+func expectNone[T any](t *testing.T, ch <-chan T, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+		t.Fatalf("unexpected %s", what)
+	default:
+	}
+}
+
+func writeFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(path), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func remove(t *testing.T, path string) {
+	t.Helper()
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoppedRebaseHoldsChanges(t *testing.T) {
+	for _, marker := range gitSequencerMarkers {
+		t.Run(marker, func(t *testing.T) {
+			w, root, timers := newGitWatcher(t, filepath.Join(".git", marker))
+			w.Start()
+
+			writeFile(t, filepath.Join(root, "main.go"))
+			receive(t, timers.holds, "changes to be held")
+			expectNone(t, w.Events, "changes while the operation is in progress")
+
+			// still in progress
+			timers.tick(t)
+			timers.tick(t)
+
+			remove(t, filepath.Join(root, ".git", marker))
+			timers.released(t, w)
+
+			// an operation stopped on conflicts is only over once concluded
+			expectNone(t, timers.timeouts, "hold timeout for "+marker)
+		})
+	}
+}
+
+func TestIndexLockHoldsChanges(t *testing.T) {
+	w, root, timers := newGitWatcher(t)
+	w.Start()
+	lock := filepath.Join(root, ".git", gitLock)
+	writeFile(t, lock)
+
+	writeFile(t, filepath.Join(root, "main.go"))
+	receive(t, timers.holds, "changes to be held")
+	if d := receive(t, timers.timeouts, "hold timeout"); d != gitMaxHold {
+		t.Errorf("hold timeout = %v, want %v", d, gitMaxHold)
+	}
+	expectNone(t, w.Events, "changes while the index is locked")
+
+	remove(t, lock)
+	timers.released(t, w)
+}
+
+func TestStaleIndexLock(t *testing.T) {
+	w, root, timers := newGitWatcher(t)
+	w.Start()
+	writeFile(t, filepath.Join(root, ".git", gitLock))
+
+	writeFile(t, filepath.Join(root, "main.go"))
+	receive(t, timers.holds, "changes to be held")
+	receive(t, timers.timeouts, "hold timeout")
+	expectNone(t, w.Events, "changes while the index is locked")
+
+	timers.timeout <- time.Now()
+	receive(t, w.Events, "the changes held")
+
+	// changes are no longer held for the stale lock
+	writeFile(t, filepath.Join(root, "other.go"))
+	receive(t, w.Events, "changes after the lock was taken to be stale")
+	expectNone(t, timers.holds, "hold for a stale lock")
+}
+
+func TestStaleIndexLockDuringRebase(t *testing.T) {
+	w, root, timers := newGitWatcher(t, filepath.Join(".git", "rebase-merge"))
+	w.Start()
+	writeFile(t, filepath.Join(root, ".git", gitLock))
+
+	writeFile(t, filepath.Join(root, "main.go"))
+	receive(t, timers.holds, "changes to be held")
+	receive(t, timers.timeouts, "hold timeout")
+
+	// the rebase itself is still in progress
+	timers.timeout <- time.Now()
+	timers.tick(t)
+	timers.tick(t)
+
+	remove(t, filepath.Join(root, ".git", "rebase-merge"))
+	timers.released(t, w)
+}
+
+func TestLockTakenBeforeFlush(t *testing.T) {
+	w, root, timers := newGitWatcher(t)
+
+	// the debounce timer only fires when told to
+	armed := make(chan struct{}, 10)
+	flush := make(chan time.Time)
+	w.newTimer = func(time.Duration) (<-chan time.Time, func() bool) {
+		armed <- struct{}{}
+		return flush, func() bool { return true }
+	}
+	w.Start()
+
+	// a file moved in is a single change
+	moved := filepath.Join(t.TempDir(), "main.go")
+	writeFile(t, moved)
+	if err := os.Rename(moved, filepath.Join(root, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, armed, "the debounce timer")
+
+	// git starts after the change was recorded, but before it is reported
+	lock := filepath.Join(root, ".git", gitLock)
+	writeFile(t, lock)
+	flush <- time.Now()
+	receive(t, timers.holds, "changes to be held")
+	expectNone(t, w.Events, "changes while the index is locked")
+
+	remove(t, lock)
+	timers.tick(t)
+	receive(t, armed, "the debounce timer")
+	flush <- time.Now()
+	receive(t, w.Events, "the changes held")
+}
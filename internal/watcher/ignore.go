@@ -0,0 +1,247 @@
+package watcher
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mktcz/wisp/internal/glob"
+)
+
+// ignoreFileNames are the files whose patterns exclude paths from watching.
+var ignoreFileNames = []string{".gitignore", ".wispignore"}
+
+// ignoreRule is a single pattern of an ignore file.
+type ignoreRule struct {
+	pattern *glob.Pattern
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreList applies the patterns of .gitignore and .wispignore files, along
+// with .git/info/exclude, using gitignore semantics. The files are read as
+// they are needed and read again after they change.
+type ignoreList struct {
+	mu sync.Mutex
+	// roots holds the directories the files are looked up from: the
+	// repository containing each watched directory, or the directory itself
+	// outside of a repository.
+	roots []string
+	// rules caches the rules of the ignore files in each directory.
+	rules  map[string][]ignoreRule
+	useGit bool
+}
+
+func newIgnoreList() *ignoreList {
+	return &ignoreList{
+		rules:  make(map[string][]ignoreRule),
+		useGit: true,
+	}
+}
+
+// addRoot starts looking up ignore files for paths under dir.
+func (l *ignoreList) addRoot(dir string) {
+	root := repositoryRoot(dir)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, existing := range l.roots {
+		if existing == root {
+			return
+		}
+	}
+	l.roots = append(l.roots, root)
+}
+
+// repositoryRoot returns the nearest directory from dir up that contains
+// .git, or dir if there is none.
+func repositoryRoot(dir string) string {
+	for current := dir; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return current
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return dir
+		}
+		current = parent
+	}
+}
+
+// isIgnoreFile reports whether path is an ignore file, whose changes update
+// the rules instead of triggering a rebuild.
+func isIgnoreFile(path string) bool {
+	base := filepath.Base(path)
+	for _, name := range ignoreFileNames {
+		if base == name {
+			return true
+		}
+	}
+	return base == "exclude" && filepath.Base(filepath.Dir(path)) == "info" &&
+		filepath.Base(filepath.Dir(filepath.Dir(path))) == ".git"
+}
+
+// forget drops the cached rules read from the ignore file at path.
+func (l *ignoreList) forget(path string) {
+	dir := filepath.Dir(path)
+	if filepath.Base(path) == "exclude" {
+		// .git/info/exclude belongs to the repository root
+		dir = filepath.Dir(filepath.Dir(dir))
+	}
+
+	l.mu.Lock()
+	delete(l.rules, dir)
+	l.mu.Unlock()
+}
+
+// ignored reports whether path, or one of its parent directories, is
+// excluded. As with git, a file cannot be included again once a directory
+// containing it is excluded.
+func (l *ignoreList) ignored(path string, isDir bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	root := l.rootOf(path)
+	if root == "" || root == path {
+		return false
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		last := i == len(parts)-1
+		current := filepath.Join(root, filepath.Join(parts[:i+1]...))
+		if l.matches(root, current, !last || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// rootOf returns the innermost root containing path. It must be called with
+// l.mu held.
+func (l *ignoreList) rootOf(path string) string {
+	best := ""
+	for _, root := range l.roots {
+		if (path == root || strings.HasPrefix(path, root+string(filepath.Separator))) && len(root) > len(best) {
+			best = root
+		}
+	}
+	return best
+}
+
+// matches applies the rules of every ignore file from root down to the
+// directory of path, where the last matching rule wins. It must be called
+// with l.mu held.
+func (l *ignoreList) matches(root, path string, isDir bool) bool {
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, rule := range l.rulesFor(dir, dir == root) {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.pattern.Match(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// rulesFor returns the rules of the ignore files in dir, reading them if
+// they are not cached. It must be called with l.mu held.
+func (l *ignoreList) rulesFor(dir string, isRoot bool) []ignoreRule {
+	if rules, ok := l.rules[dir]; ok {
+		return rules
+	}
+
+	var files []string
+	if l.useGit {
+		if isRoot {
+			files = append(files, filepath.Join(dir, ".git", "info", "exclude"))
+		}
+		files = append(files, filepath.Join(dir, ".gitignore"))
+	}
+	files = append(files, filepath.Join(dir, ".wispignore"))
+
+	var rules []ignoreRule
+	for _, file := range files {
+		rules = append(rules, readIgnoreFile(file)...)
+	}
+	l.rules[dir] = rules
+	return rules
+}
+
+func readIgnoreFile(path string) []ignoreRule {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// parseIgnoreLine turns a line of an ignore file into a rule, following the
+// gitignore format.
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// patterns are relative to the directory of the ignore file
+	pattern, err := glob.Compile(line)
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.pattern = pattern
+	return rule, true
+}
@@ -0,0 +1,186 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newIgnoreTree creates a repository holding the given files, and returns
+// its root.
+func newIgnoreTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, ".git", "info"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestIgnoreList(t *testing.T) {
+	root := newIgnoreTree(t, map[string]string{
+		".gitignore": `# build output
+*.log
+!keep.log
+build/
+!build/keep.go
+/vendor
+docs/**/*.tmp
+\#notes
+\!bang
+` + "trailing\\ \nspaces   \n",
+		".wispignore": `secret.txt
+!important.log
+`,
+		".git/info/exclude": "excluded.go\n",
+		"sub/.gitignore": `!*.log
+local.txt
+`,
+		"sub/deeper/.wispignore": "*.go\n",
+	})
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "main.go", want: false},
+		{path: "# build output", want: false},
+
+		// wildcards match at any depth unless anchored
+		{path: "app.log", want: true},
+		{path: "a/b/app.log", want: true},
+		{path: "vendor", isDir: true, want: true},
+		{path: "vendor/lib.go", want: true},
+		{path: "sub/vendor", isDir: true, want: false},
+		{path: "docs/x.tmp", want: true},
+		{path: "docs/a/b/x.tmp", want: true},
+		{path: "x.tmp", want: false},
+
+		// directory-only patterns
+		{path: "build", isDir: true, want: true},
+		{path: "build", want: false},
+		{path: "sub/build", isDir: true, want: true},
+		{path: "build/main.go", want: true},
+		// a file cannot be included again once its directory is excluded
+		{path: "build/keep.go", want: true},
+
+		// negation, where the last matching rule wins
+		{path: "keep.log", want: false},
+		// .wispignore is applied after .gitignore
+		{path: "important.log", want: false},
+		{path: "secret.txt", want: true},
+		// deeper ignore files override those above them
+		{path: "sub/app.log", want: false},
+		{path: "sub/local.txt", want: true},
+		{path: "local.txt", want: false},
+		{path: "sub/deeper/main.go", want: true},
+		{path: "sub/main.go", want: false},
+
+		// escapes and trailing spaces
+		{path: "#notes", want: true},
+		{path: "!bang", want: true},
+		{path: "trailing ", want: true},
+		{path: "trailing", want: false},
+		{path: "spaces", want: true},
+
+		// .git/info/exclude
+		{path: "excluded.go", want: true},
+		{path: "sub/excluded.go", want: true},
+	}
+
+	l := newIgnoreList()
+	l.addRoot(root)
+	for _, tt := range tests {
+		path := filepath.Join(root, filepath.FromSlash(tt.path))
+		if got := l.ignored(path, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q, dir=%v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreListWithoutGit(t *testing.T) {
+	root := newIgnoreTree(t, map[string]string{
+		".gitignore":        "*.log\n",
+		".git/info/exclude": "excluded.go\n",
+		".wispignore":       "secret.txt\n",
+	})
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "app.log", want: false},
+		{path: "excluded.go", want: false},
+		{path: "secret.txt", want: true},
+	}
+
+	l := newIgnoreList()
+	l.useGit = false
+	l.addRoot(root)
+	for _, tt := range tests {
+		if got := l.ignored(filepath.Join(root, tt.path), false); got != tt.want {
+			t.Errorf("ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreListForget(t *testing.T) {
+	root := newIgnoreTree(t, map[string]string{".wispignore": "*.log\n"})
+	l := newIgnoreList()
+	l.addRoot(root)
+
+	path := filepath.Join(root, "app.log")
+	if !l.ignored(path, false) {
+		t.Fatal("app.log is not ignored")
+	}
+
+	ignoreFile := filepath.Join(root, ".wispignore")
+	if err := os.WriteFile(ignoreFile, []byte("*.tmp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l.forget(ignoreFile)
+	if l.ignored(path, false) {
+		t.Error("app.log is still ignored after the ignore file changed")
+	}
+}
+
+func TestParseIgnoreLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		ok      bool
+		negate  bool
+		dirOnly bool
+	}{
+		{line: "", ok: false},
+		{line: "   ", ok: false},
+		{line: "# comment", ok: false},
+		{line: "/", ok: false},
+		{line: "!", ok: false},
+		{line: "*.log", ok: true},
+		{line: "*.log\r", ok: true},
+		{line: "!*.log", ok: true, negate: true},
+		{line: "build/", ok: true, dirOnly: true},
+		{line: "!build/", ok: true, negate: true, dirOnly: true},
+		{line: `\!bang`, ok: true},
+		{line: `\#notes`, ok: true},
+	}
+
+	for _, tt := range tests {
+		rule, ok := parseIgnoreLine(tt.line)
+		if ok != tt.ok || rule.negate != tt.negate || rule.dirOnly != tt.dirOnly {
+			t.Errorf("parseIgnoreLine(%q) = negate %v, dirOnly %v, %v, want negate %v, dirOnly %v, %v",
+				tt.line, rule.negate, rule.dirOnly, ok, tt.negate, tt.dirOnly, tt.ok)
+		}
+	}
+}
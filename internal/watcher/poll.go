@@ -0,0 +1,211 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollBackend finds changes by listing the watched directories at a fixed
+// interval, for file systems that do not deliver notifications, such as
+// shared folders of virtual machines and network mounts.
+type pollBackend struct {
+	interval time.Duration
+	// hash compares the contents of files whose modification time or size
+	// changed, reporting only those whose contents changed too, for file
+	// systems that touch files without modifying them.
+	hash   bool
+	events chan<- fsnotify.Event
+
+	mu sync.Mutex
+	// dirs holds the entries of each watched directory as of the last scan.
+	dirs map[string]map[string]fileState
+	done chan struct{}
+}
+
+// fileState is what a scan compares to tell that a file changed.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+	sum     [sha256.Size]byte
+}
+
+func newPollBackend(interval time.Duration, hash bool, events chan<- fsnotify.Event) *pollBackend {
+	b := &pollBackend{
+		interval: interval,
+		hash:     hash,
+		events:   events,
+		dirs:     make(map[string]map[string]fileState),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *pollBackend) Add(dir string) error {
+	b.mu.Lock()
+	_, watched := b.dirs[dir]
+	b.mu.Unlock()
+	if watched {
+		return nil
+	}
+
+	entries, err := b.list(dir, nil)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.dirs[dir] = entries
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *pollBackend) Remove(dir string) error {
+	b.mu.Lock()
+	delete(b.dirs, dir)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *pollBackend) Close() error {
+	close(b.done)
+	return nil
+}
+
+func (b *pollBackend) run() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.scan()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// scan lists every watched directory and reports what changed since the
+// previous scan.
+func (b *pollBackend) scan() {
+	b.mu.Lock()
+	dirs := make([]string, 0, len(b.dirs))
+	for dir := range b.dirs {
+		dirs = append(dirs, dir)
+	}
+	b.mu.Unlock()
+
+	for _, dir := range dirs {
+		b.mu.Lock()
+		previous, watched := b.dirs[dir]
+		b.mu.Unlock()
+		if !watched {
+			continue
+		}
+
+		current, err := b.list(dir, previous)
+		if err != nil {
+			// removed, which the scan of its parent reports
+			b.Remove(dir)
+			continue
+		}
+
+		b.mu.Lock()
+		if _, watched := b.dirs[dir]; watched {
+			b.dirs[dir] = current
+		}
+		b.mu.Unlock()
+
+		for name, state := range current {
+			old, existed := previous[name]
+			switch {
+			case !existed:
+				b.send(filepath.Join(dir, name), fsnotify.Create)
+			case state.mode.IsDir():
+				// the modification time of a directory changes with its
+				// entries, which its own scan reports
+			case state.mode != old.mode:
+				b.send(filepath.Join(dir, name), fsnotify.Write)
+			case b.hash && state.mode.IsRegular():
+				if state.sum != old.sum {
+					b.send(filepath.Join(dir, name), fsnotify.Write)
+				}
+			case state.modTime != old.modTime || state.size != old.size:
+				b.send(filepath.Join(dir, name), fsnotify.Write)
+			}
+		}
+		for name := range previous {
+			if _, exists := current[name]; !exists {
+				b.send(filepath.Join(dir, name), fsnotify.Remove)
+			}
+		}
+
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+	}
+}
+
+// list returns the state of the entries of dir. Only the directory itself is
+// read, along with, if hashing is enabled, the contents of the files whose
+// modification time or size differs from their state in previous.
+func (b *pollBackend) list(dir string, previous map[string]fileState) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]fileState, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		state := fileState{modTime: info.ModTime(), size: info.Size(), mode: info.Mode()}
+		if b.hash && info.Mode().IsRegular() {
+			old, ok := previous[entry.Name()]
+			if ok && old.modTime.Equal(state.modTime) && old.size == state.size && old.mode == state.mode {
+				state.sum = old.sum
+			} else {
+				state.sum, _ = hashFile(filepath.Join(dir, entry.Name()))
+			}
+		}
+		states[entry.Name()] = state
+	}
+	return states, nil
+}
+
+func (b *pollBackend) send(path string, op fsnotify.Op) {
+	select {
+	case b.events <- fsnotify.Event{Name: path, Op: op}:
+	case <-b.done:
+	}
+}
+
+// hashFile returns the SHA-256 sum of a file's contents.
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+
+	file, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return sum, err
+	}
+	copy(sum[:], hash.Sum(nil))
+	return sum, nil
+}
@@ -1,46 +1,96 @@
 package watcher
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
+// DefaultPollInterval is how often directories are listed when polling.
+const DefaultPollInterval = 500 * time.Millisecond
+
 type Watcher struct {
-	watcher       *fsnotify.Watcher
-	debounceTime  time.Duration
+	mu      sync.Mutex
+	backend backend
+	polling bool
+	// watched holds the directories added to the backend, which are added
+	// to the polling backend when falling back to it.
+	watched      map[string]bool
+	pollInterval time.Duration
+	pollHash     bool
+	// events and errors receive what the backend reports.
+	events chan fsnotify.Event
+	errors chan error
+
+	debounceTime time.Duration
+	// newTimer makes the debounce timer, returning its channel and a
+	// function that stops it.
+	newTimer      func(d time.Duration) (<-chan time.Time, func() bool)
 	ignoreDirs    []string
 	excludeDirs   []string
 	excludeFiles  []string
 	excludeRegex  []*regexp.Regexp
+	includeExts   map[string]bool
+	includeFiles  []string
 	followSymlink bool
-	Events        chan struct{}
-	Errors        chan error
-	done          chan struct{}
+	onlyFiles     map[string]bool
+	ignoreFiles   map[string]bool
+	// dirs holds the directories set with SetDirs, which are watched
+	// without their subdirectories.
+	dirs   map[string]bool
+	filter func(path string) bool
+	// ignores applies the .gitignore and .wispignore files of the watched
+	// directories.
+	ignores *ignoreList
+	// contents remembers the contents of the watched files, when changes
+	// that leave them as they were are ignored.
+	contents *contentIndex
+	// git holds the repositories whose git operations changes wait for.
+	git *gitRepos
+	// pending collects the changes since the last report.
+	pending batcher
+	// Events receives the changes made during each debounce period.
+	Events chan Batch
+	Errors chan error
+	done   chan struct{}
 }
 
 func New(debounceTime time.Duration) (*Watcher, error) {
-	fsWatcher, err := fsnotify.NewWatcher()
+	events := make(chan fsnotify.Event, 100)
+	errs := make(chan error, 10)
+	notify, err := newNotifyBackend(events, errs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create watcher: %w", err)
+		return nil, err
 	}
 
 	w := &Watcher{
-		watcher:      fsWatcher,
+		backend:      notify,
+		watched:      make(map[string]bool),
+		pollInterval: DefaultPollInterval,
+		events:       events,
+		errors:       errs,
 		debounceTime: debounceTime,
+		newTimer: func(d time.Duration) (<-chan time.Time, func() bool) {
+			timer := time.NewTimer(d)
+			return timer.C, timer.Stop
+		},
 		ignoreDirs: []string{
 			"tmp", ".tmp", "vendor", ".git", ".idea", ".vscode",
 			"node_modules", "dist", "build", ".next", ".nuxt",
 		},
-		Events: make(chan struct{}, 1),
-		Errors: make(chan error, 10),
-		done:   make(chan struct{}),
+		ignores: newIgnoreList(),
+		Events:  make(chan Batch, 1),
+		Errors:  make(chan error, 10),
+		done:    make(chan struct{}),
 	}
 
 	return w, nil
@@ -61,11 +111,129 @@ func (w *Watcher) SetExcludes(dirs []string, files []string, regexPatterns []str
 	return nil
 }
 
+// SetIncludes restricts the changes reported to files with one of the given
+// extensions, with or without the leading dot, or whose names match one of
+// the given patterns. Without either every file is reported.
+func (w *Watcher) SetIncludes(exts []string, files []string) {
+	if len(exts) > 0 {
+		w.includeExts = make(map[string]bool)
+		for _, ext := range exts {
+			w.includeExts["."+strings.TrimPrefix(ext, ".")] = true
+		}
+	}
+	w.includeFiles = files
+}
+
 func (w *Watcher) SetFollowSymlink(follow bool) {
 	w.followSymlink = follow
 }
 
+// SetPollOptions sets how often directories are listed when polling, and
+// whether file contents are compared too, for file systems whose
+// modification times cannot be relied on.
+func (w *Watcher) SetPollOptions(interval time.Duration, hash bool) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	w.pollInterval = interval
+	w.pollHash = hash
+}
+
+// UsePolling finds changes by listing the watched directories periodically
+// instead of relying on notifications, which do not arrive on some shared
+// folders and network mounts.
+func (w *Watcher) UsePolling() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.switchToPolling()
+}
+
+// switchToPolling replaces the backend with a polling one watching the same
+// directories. It must be called with w.mu held.
+func (w *Watcher) switchToPolling() {
+	if w.polling {
+		return
+	}
+
+	poll := newPollBackend(w.pollInterval, w.pollHash, w.events)
+	for dir := range w.watched {
+		if err := poll.Add(dir); err != nil {
+			delete(w.watched, dir)
+		}
+	}
+	w.backend.Close()
+	w.backend = poll
+	w.polling = true
+}
+
+// add watches the entries of dir, falling back to polling once the system
+// runs out of inotify watches.
+func (w *Watcher) add(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.backend.Add(dir)
+	if errors.Is(err, syscall.ENOSPC) && !w.polling {
+		log.Printf("Warning: out of inotify watches, polling for changes instead (raise fs.inotify.max_user_watches to avoid this)")
+		w.switchToPolling()
+		err = w.backend.Add(dir)
+	}
+	if err != nil {
+		return err
+	}
+	w.watched[dir] = true
+	return nil
+}
+
+func (w *Watcher) remove(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.backend.Remove(dir)
+	delete(w.watched, dir)
+}
+
+// SetUseGitignore sets whether .gitignore files and .git/info/exclude are
+// applied. .wispignore files always are.
+func (w *Watcher) SetUseGitignore(use bool) {
+	w.ignores.useGit = use
+}
+
+// SetIgnoreUnchanged sets whether changes that leave a file's contents as
+// they were, such as saving an unmodified file or touching it, are ignored.
+// It must be called before the directories are watched, for the files in
+// them to be recorded as they are.
+func (w *Watcher) SetIgnoreUnchanged(ignore bool) {
+	if !ignore {
+		w.contents = nil
+	} else if w.contents == nil {
+		w.contents = newContentIndex()
+	}
+}
+
+// SetWaitForGit sets whether changes are held while a git operation, such
+// as a checkout or a rebase, is in progress in the repository of a watched
+// directory, and reported as one batch once it has finished. It must be
+// called before the directories are watched.
+func (w *Watcher) SetWaitForGit(wait bool) {
+	if !wait {
+		w.git = nil
+	} else if w.git == nil {
+		w.git = newGitRepos()
+	}
+}
+
+// addRoot applies the ignore files and watches for the git operations of the
+// repository dir is in.
+func (w *Watcher) addRoot(dir string) {
+	w.ignores.addRoot(dir)
+	if w.git != nil {
+		w.git.add(dir)
+	}
+}
+
 func (w *Watcher) Watch(dir string) error {
+	w.addRoot(absPath(dir))
 
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -75,11 +243,16 @@ func (w *Watcher) Watch(dir string) error {
 		if info.IsDir() && w.shouldIgnore(path) {
 			return filepath.SkipDir
 		}
+		if info.IsDir() && path != dir && w.ignored(path, true) {
+			return filepath.SkipDir
+		}
 
 		if info.IsDir() {
-			if err := w.watcher.Add(path); err != nil {
+			if err := w.add(path); err != nil {
 				log.Printf("Warning: failed to watch %s: %v", path, err)
 			}
+		} else {
+			w.recordContents(path, info)
 		}
 
 		return nil
@@ -92,58 +265,204 @@ func (w *Watcher) Watch(dir string) error {
 	return nil
 }
 
+// WatchFiles watches individual files instead of directory trees. Their
+// parent directories are watched so that editors replacing a file are still
+// noticed, and only events for the given files are reported.
+func (w *Watcher) WatchFiles(paths ...string) error {
+	if w.onlyFiles == nil {
+		w.onlyFiles = make(map[string]bool)
+	}
+
+	dirs := make(map[string]bool)
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+		w.onlyFiles[absPath] = true
+		if info, err := os.Stat(absPath); err == nil {
+			w.recordContents(absPath, info)
+		}
+
+		dir := filepath.Dir(absPath)
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		if w.git != nil {
+			w.git.add(dir)
+		}
+		if err := w.add(dir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+// SetDirs watches exactly the given directories, without their
+// subdirectories, and stops watching those set by an earlier call.
+func (w *Watcher) SetDirs(dirs ...string) error {
+	if w.dirs == nil {
+		w.dirs = make(map[string]bool)
+	}
+
+	wanted := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		w.addRoot(absPath(dir))
+		wanted[dir] = true
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.add(dir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+		w.dirs[dir] = true
+		if entries, err := os.ReadDir(dir); err == nil {
+			for _, entry := range entries {
+				if info, err := entry.Info(); err == nil && !entry.IsDir() {
+					w.recordContents(filepath.Join(dir, entry.Name()), info)
+				}
+			}
+		}
+	}
+
+	for dir := range w.dirs {
+		if !wanted[dir] {
+			w.remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+
+	return nil
+}
+
+// recordContents remembers a watched file as it is, when ignoring the changes
+// that leave files as they were.
+func (w *Watcher) recordContents(path string, info os.FileInfo) {
+	if w.contents != nil && !w.shouldSkipEvent(fsnotify.Event{Name: path, Op: fsnotify.Write}) {
+		w.contents.record(path, info)
+	}
+}
+
+// SetFilter reports only the changes to paths for which filter returns true,
+// in place of the include lists. Exclusions still apply.
+func (w *Watcher) SetFilter(filter func(path string) bool) {
+	w.filter = filter
+}
+
+// IgnoreFiles stops changes to the given files from being reported. It may
+// be called while the watcher is running.
+func (w *Watcher) IgnoreFiles(paths ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.ignoreFiles == nil {
+		w.ignoreFiles = make(map[string]bool)
+	}
+	for _, path := range paths {
+		if absPath, err := filepath.Abs(path); err == nil {
+			w.ignoreFiles[absPath] = true
+		}
+	}
+}
+
 func (w *Watcher) Start() {
+	if w.contents != nil {
+		go w.contents.hashBaselines(w.done)
+	}
 	go w.run()
 }
 
 func (w *Watcher) Stop() error {
 	close(w.done)
-	return w.watcher.Close()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.backend.Close()
 }
 
 func (w *Watcher) run() {
-	var (
-		timer       *time.Timer
-		timerActive bool
-	)
+	// the debounce timer, which the loop handles so that a git operation
+	// started meanwhile can still hold the changes
+	var flushC <-chan time.Time
+	stopTimer := func() bool { return false }
+	debounce := func() {
+		stopTimer()
+		flushC, stopTimer = w.newTimer(w.debounceTime)
+	}
+
+	// while a git operation is in progress, changes are held and the
+	// repositories checked until it has finished, or an index lock has been
+	// held for so long that it must be stale
+	var gitCheck, gitTimeout <-chan time.Time
+	var stopGitCheck func()
+	var gitHeld string
+	hold := func(marker string) {
+		if gitCheck == nil {
+			log.Printf("Holding changes until the git operation in progress finishes (%s exists)", marker)
+			gitCheck, stopGitCheck = w.git.newTicker(gitPollInterval)
+		}
+		if marker != gitHeld {
+			gitHeld = marker
+			gitTimeout = nil
+			if isLock(marker) {
+				gitTimeout = w.git.after(w.git.maxHold)
+			}
+		}
+	}
+	release := func() {
+		stopGitCheck()
+		gitCheck, gitTimeout, stopGitCheck, gitHeld = nil, nil, nil, ""
+		debounce()
+	}
 
 	for {
 		select {
-		case event, ok := <-w.watcher.Events:
-			if !ok {
-				return
+		case event := <-w.events:
+			if !w.record(event) {
+				continue
 			}
 
-			if w.shouldSkipEvent(event) {
+			stopTimer()
+			flushC = nil
+			if w.git != nil {
+				if marker := w.git.busy(); marker != "" {
+					hold(marker)
+				}
+			}
+			if gitCheck != nil {
 				continue
 			}
-
-			if timerActive && timer != nil {
-				timer.Stop()
+			debounce()
+
+		case <-flushC:
+			flushC = nil
+			if w.git != nil {
+				if marker := w.git.busy(); marker != "" {
+					hold(marker)
+					continue
+				}
 			}
+			go w.flush()
 
-			timer = time.AfterFunc(w.debounceTime, func() {
-				select {
-				case w.Events <- struct{}{}:
-				default:
-
-				}
-				timerActive = false
-			})
-			timerActive = true
-
-			if event.Op&fsnotify.Create == fsnotify.Create {
-				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
-					if !w.shouldIgnore(event.Name) {
-						w.watcher.Add(event.Name)
-					}
-				}
+		case <-gitCheck:
+			if marker := w.git.busy(); marker != "" {
+				hold(marker)
+				continue
 			}
+			release()
 
-		case err, ok := <-w.watcher.Errors:
-			if !ok {
-				return
+		case <-gitTimeout:
+			log.Printf("Warning: %s still exists after %v, assuming it is stale and reporting the changes held", gitHeld, w.git.maxHold)
+			w.git.markStale(gitHeld)
+			if marker := w.git.busy(); marker != "" {
+				hold(marker)
+				continue
 			}
+			release()
+
+		case err := <-w.errors:
 			select {
 			case w.Errors <- err:
 			default:
@@ -151,14 +470,101 @@ func (w *Watcher) run() {
 			}
 
 		case <-w.done:
-			if timer != nil {
-				timer.Stop()
+			stopTimer()
+			if stopGitCheck != nil {
+				stopGitCheck()
 			}
 			return
 		}
 	}
 }
 
+// record adds an event to the pending changes, reporting whether it was
+// kept. A new directory is watched along with its subdirectories, and the
+// files already in them, created before they were watched, are recorded in
+// its place.
+func (w *Watcher) record(event fsnotify.Event) bool {
+	if event.Op&fsnotify.Create == fsnotify.Create && w.onlyFiles == nil && w.dirs == nil {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			recorded := false
+			for _, path := range w.watchNewDir(event.Name) {
+				if w.record(fsnotify.Event{Name: path, Op: fsnotify.Create}) {
+					recorded = true
+				}
+			}
+			return recorded
+		}
+	}
+
+	// an edited ignore file changes what is watched from now on, but there
+	// is nothing to rebuild
+	if w.onlyFiles == nil && isIgnoreFile(event.Name) {
+		w.ignores.forget(absPath(event.Name))
+		return false
+	}
+
+	if w.shouldSkipEvent(event) {
+		return false
+	}
+	w.pending.add(filepath.Clean(event.Name), event.Op)
+	return true
+}
+
+// watchNewDir watches a new directory and its subdirectories, returning the
+// files in them.
+func (w *Watcher) watchNewDir(dir string) []string {
+	var files []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			return nil
+		}
+		if w.shouldIgnore(path) || w.ignored(path, true) {
+			return filepath.SkipDir
+		}
+		if err := w.add(path); err != nil {
+			log.Printf("Warning: failed to watch %s: %v", path, err)
+		}
+		return nil
+	})
+	return files
+}
+
+// flush reports the changes made since the last report.
+func (w *Watcher) flush() {
+	batch := w.settle(w.pending.take())
+	if len(batch.Changes) == 0 {
+		return
+	}
+
+	select {
+	case w.Events <- batch:
+	case <-w.done:
+	}
+}
+
+// settle drops the changes that came to nothing by the end of the debounce
+// period: files created and removed again, such as the temporary files of
+// editors and formatters, and, when ignoring unchanged files, files whose
+// contents are as they were.
+func (w *Watcher) settle(batch Batch) Batch {
+	changes := batch.Changes[:0]
+	for _, change := range batch.Changes {
+		if _, err := os.Lstat(change.Path); err != nil && change.created {
+			continue
+		}
+		if w.contents != nil && !w.contents.changed(change.Path) {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	batch.Changes = changes
+	return batch
+}
+
 func (w *Watcher) shouldIgnore(path string) bool {
 	base := filepath.Base(path)
 
@@ -193,6 +599,13 @@ func (w *Watcher) shouldSkipEvent(event fsnotify.Event) bool {
 		return true
 	}
 
+	if w.onlyFiles != nil && !w.onlyFiles[filepath.Clean(event.Name)] {
+		return true
+	}
+	if w.ignoredFile(event.Name) {
+		return true
+	}
+
 	base := filepath.Base(event.Name)
 	ext := filepath.Ext(event.Name)
 
@@ -220,5 +633,49 @@ func (w *Watcher) shouldSkipEvent(event fsnotify.Event) bool {
 		}
 	}
 
+	if w.onlyFiles == nil {
+		info, err := os.Stat(event.Name)
+		if w.ignored(event.Name, err == nil && info.IsDir()) {
+			return true
+		}
+	}
+
+	if w.filter != nil {
+		return !w.filter(event.Name)
+	}
+	return !w.included(base, ext)
+}
+
+func (w *Watcher) included(base, ext string) bool {
+	if w.includeExts == nil && len(w.includeFiles) == 0 {
+		return true
+	}
+	if w.includeExts[ext] {
+		return true
+	}
+	for _, pattern := range w.includeFiles {
+		if matched, _ := filepath.Match(pattern, base); matched {
+			return true
+		}
+	}
 	return false
 }
+
+// ignoredFile reports whether path was passed to IgnoreFiles.
+func (w *Watcher) ignoredFile(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ignoreFiles[absPath(path)]
+}
+
+// ignored reports whether path is excluded by an ignore file.
+func (w *Watcher) ignored(path string, isDir bool) bool {
+	return w.ignores.ignored(absPath(path), isDir)
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -11,6 +12,7 @@ import (
 	"github.com/mktcz/wisp/internal/config"
 	"github.com/mktcz/wisp/internal/generator"
 	"github.com/mktcz/wisp/internal/runner"
+	"github.com/mktcz/wisp/internal/session"
 )
 
 const (
@@ -29,15 +31,18 @@ func main() {
 	var (
 		showHelp    bool
 		showVersion bool
-		configFile  string
+		configFiles configList
+		profile     string
 	)
 
 	flag.BoolVar(&showHelp, "help", false, "Show help message")
 	flag.BoolVar(&showHelp, "h", false, "Show help message (shorthand)")
 	flag.BoolVar(&showVersion, "version", false, "Show version information")
 	flag.BoolVar(&showVersion, "v", false, "Show version information (shorthand)")
-	flag.StringVar(&configFile, "config", "wisp.toml", "Path to configuration file")
-	flag.StringVar(&configFile, "c", "wisp.toml", "Path to configuration file (shorthand)")
+	flag.Var(&configFiles, "config", "Path to configuration file, repeat to layer several")
+	flag.Var(&configFiles, "c", "Path to configuration file (shorthand)")
+	flag.StringVar(&profile, "profile", os.Getenv("WISP_PROFILE"), "Name of the profile to apply")
+	flag.StringVar(&profile, "p", os.Getenv("WISP_PROFILE"), "Name of the profile to apply (shorthand)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "%s\n", banner)
@@ -46,21 +51,32 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  wisp              Run all applications defined in wisp.toml\n")
 		fmt.Fprintf(os.Stderr, "  wisp init         Create a sample wisp.toml configuration\n")
 		fmt.Fprintf(os.Stderr, "  wisp run <app>    Run a specific application\n")
+		fmt.Fprintf(os.Stderr, "  wisp validate     Check wisp.toml for mistakes\n")
+		fmt.Fprintf(os.Stderr, "  wisp config print Show the effective configuration and where each value comes from\n")
 		fmt.Fprintf(os.Stderr, "  wisp --help       Show this help message\n")
 		fmt.Fprintf(os.Stderr, "  wisp --version    Show version information\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		fmt.Fprintf(os.Stderr, "  -c, --config      Path to configuration file (default: wisp.toml)\n")
+		fmt.Fprintf(os.Stderr, "                    Repeat to layer several files; wisp.local.toml is merged automatically\n")
+		fmt.Fprintf(os.Stderr, "  -p, --profile     Apply a [profile.<name>] section (default: $WISP_PROFILE)\n")
 		fmt.Fprintf(os.Stderr, "  -h, --help        Show help message\n")
 		fmt.Fprintf(os.Stderr, "  -v, --version     Show version information\n\n")
 		fmt.Fprintf(os.Stderr, "Examples:\n")
 		fmt.Fprintf(os.Stderr, "  wisp              # Run all apps defined in wisp.toml\n")
 		fmt.Fprintf(os.Stderr, "  wisp run api      # Run only the 'api' application\n")
 		fmt.Fprintf(os.Stderr, "  wisp init         # Create a sample wisp.toml file\n")
-		fmt.Fprintf(os.Stderr, "  wisp -c custom.toml  # Use a custom config file\n\n")
+		fmt.Fprintf(os.Stderr, "  wisp validate     # Check the configuration (exits 1 on errors)\n")
+		fmt.Fprintf(os.Stderr, "  wisp -c custom.toml  # Use a custom config file\n")
+		fmt.Fprintf(os.Stderr, "  wisp -c wisp.toml -c ci.toml  # Layer ci.toml on top of wisp.toml\n")
+		fmt.Fprintf(os.Stderr, "  wisp --profile race run api   # Run 'api' with the race profile\n\n")
 	}
 
 	flag.Parse()
 
+	if len(configFiles) == 0 {
+		configFiles = configList{"wisp.toml"}
+	}
+
 	if showVersion {
 		fmt.Printf("Wisp version %s\n", version)
 		os.Exit(0)
@@ -80,14 +96,21 @@ func main() {
 	switch command {
 	case "init":
 		handleInit()
+	case "validate":
+		handleValidate(configFiles, profile)
+	case "config":
+		if len(args) < 2 || args[1] != "print" {
+			log.Fatal("Error: usage: wisp config print")
+		}
+		handleConfigPrint(configFiles, profile)
 	case "run":
 		if len(args) < 2 {
 			log.Fatal("Error: 'run' command requires an application name")
 		}
-		handleRun(configFile, args[1:]...)
+		handleRun(configFiles, profile, args[1:]...)
 	case "":
 		// run all apps
-		handleRun(configFile)
+		handleRun(configFiles, profile)
 	default:
 		log.Fatalf("Unknown command: %s\nRun 'wisp --help' for usage", command)
 	}
@@ -131,17 +154,89 @@ func handleInit() {
 	}
 }
 
+// configList collects every -c/--config flag in the order given
+type configList []string
+
+func (c *configList) String() string {
+	return strings.Join(*c, ", ")
+}
+
+func (c *configList) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
+
+// checks the configuration and exits non-zero if it contains errors
+func handleValidate(configFiles []string, profile string) {
+	diagnostics, err := config.Validate(configFiles, config.Options{Profile: profile})
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	for _, d := range diagnostics {
+		fmt.Fprintln(os.Stderr, d)
+	}
+
+	if config.HasErrors(diagnostics) {
+		os.Exit(1)
+	}
+
+	fmt.Printf("✓ %s valid\n", describeFiles(config.ConfigFiles(configFiles)))
+}
+
+// prints the merged configuration annotated with the origin of each value
+func handleConfigPrint(configFiles []string, profile string) {
+	cfg, err := config.LoadWithOptions(configFiles, config.Options{
+		SessionDir: "/tmp/wisp/<session>",
+		Profile:    profile,
+	})
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if err := cfg.WriteEffective(os.Stdout); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+func describeFiles(files []string) string {
+	if len(files) == 1 {
+		return files[0] + " is"
+	}
+	return strings.Join(files, ", ") + " are"
+}
+
 // loads the configuration and runs the specified apps
-func handleRun(configFile string, appNames ...string) {
+func handleRun(configFiles []string, profile string, appNames ...string) {
 	// print banner
 	fmt.Print(banner)
 	fmt.Printf("Wisp %s - Starting...\n\n", version)
 
+	// validate before loading so mistakes are reported with their position
+	// instead of being silently ignored
+	if diagnostics, err := config.Validate(configFiles, config.Options{Profile: profile}); err == nil {
+		for _, d := range diagnostics {
+			log.Println(d)
+		}
+		if config.HasErrors(diagnostics) {
+			log.Fatal("Invalid configuration, run 'wisp validate' after fixing the errors above")
+		}
+	}
+
+	// pick the session directory up front so ${session_dir} can be expanded
+	sessionDir, err := session.NewSessionPath()
+	if err != nil {
+		log.Fatalf("Failed to create session: %v", err)
+	}
+
 	// load configuration
-	cfg, err := config.Load(configFile)
+	cfg, err := config.LoadWithOptions(configFiles, config.Options{
+		SessionDir: sessionDir,
+		Profile:    profile,
+	})
 	if err != nil {
 		if os.IsNotExist(err) || strings.Contains(err.Error(), "not found") {
-			log.Printf("Configuration file '%s' not found.\n", configFile)
+			log.Printf("Configuration file '%s' not found.\n", strings.Join(configFiles, "', '"))
 			log.Println("Run 'wisp init' to create a sample configuration.")
 			os.Exit(1)
 		}
@@ -156,6 +251,24 @@ func handleRun(configFile string, appNames ...string) {
 	// create and run the runner
 	r := runner.New(cfg)
 
+	// reload the configuration when it changes, keeping the session and
+	// profile; edits that fail validation are rejected
+	opts := config.Options{SessionDir: sessionDir, Profile: profile}
+	r.SetConfigLoader(func() (*config.Config, error) {
+		diagnostics, err := config.Validate(configFiles, opts)
+		if err != nil {
+			return nil, err
+		}
+		if config.HasErrors(diagnostics) {
+			messages := make([]string, len(diagnostics))
+			for i, d := range diagnostics {
+				messages[i] = d.String()
+			}
+			return nil, errors.New(strings.Join(messages, "\n"))
+		}
+		return config.LoadWithOptions(configFiles, opts)
+	})
+
 	// run specified apps or all apps if no app names are provided
 	if err := r.Run(appNames...); err != nil {
 		log.Fatalf("Error: %v", err)